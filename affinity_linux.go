@@ -0,0 +1,51 @@
+//go:build linux
+
+package goscript
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// maxCPUs is the number of CPUs representable in the affinity mask.
+const maxCPUs = 1024
+
+// setCPUAffinity sets the CPU affinity of every thread in the process.
+// sched_setaffinity only affects a single thread, so the task list is
+// walked until no new threads appear; threads (and child processes)
+// created afterwards inherit the mask.
+func setCPUAffinity(pid int, cpus []int) error {
+	var mask [maxCPUs / 64]uint64
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= maxCPUs {
+			return fmt.Errorf("goscript: invalid cpu %d", cpu)
+		}
+		mask[cpu/64] |= 1 << (uint(cpu) % 64)
+	}
+	done := make(map[int]bool)
+	for {
+		tasks, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+		if err != nil {
+			return err
+		}
+		changed := false
+		for _, task := range tasks {
+			tid, err := strconv.Atoi(task.Name())
+			if err != nil || done[tid] {
+				continue
+			}
+			_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(tid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+			if errno != 0 && errno != syscall.ESRCH {
+				return fmt.Errorf("goscript: sched_setaffinity: %s", errno)
+			}
+			done[tid] = true
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package goscript
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestCPUAffinity(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import (
+	"io/ioutil"
+	"strings"
+)
+
+func goscript() (string, error) {
+	b, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "Cpus_allowed_list:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "Cpus_allowed_list:")), nil
+		}
+	}
+	return "", nil
+}
+`, WithCPUAffinity([]int{0}))
+	defer script.Close()
+	cpus, err := script.Execute()
+	is.NoErr(err) // Execute
+	is.Equal(cpus, "0")
+}
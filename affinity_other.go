@@ -0,0 +1,9 @@
+//go:build !linux
+
+package goscript
+
+import "errors"
+
+func setCPUAffinity(pid int, cpus []int) error {
+	return errors.New("goscript: CPU affinity is only supported on linux")
+}
@@ -0,0 +1,69 @@
+package goscript
+
+import (
+	"context"
+	"errors"
+)
+
+// ExecuteBatch executes the script once for each of the argument lists,
+// and returns the values and errors of the calls in the same order.
+// The calls are sent to the script process together, and their
+// results come back together, so a large number of small calls avoids
+// paying for a round trip each.
+// Errors that stop the whole batch, such as the script process
+// exiting, are returned for every call that was sent.
+func (s *Script) ExecuteBatch(argsList [][]interface{}) ([]interface{}, []error) {
+	values := make([]interface{}, len(argsList))
+	errs := make([]error, len(argsList))
+	s.ensureStarted()
+	s.mu.RLock()
+	p, err := s.proc, s.err
+	s.mu.RUnlock()
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return values, errs
+	}
+	// batch holds the calls that can be made, and indexes their
+	// positions in argsList
+	var batch [][]interface{}
+	var indexes []int
+	for i, args := range argsList {
+		args, err := p.prepareArgs("", args)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		batch = append(batch, args)
+		indexes = append(indexes, i)
+	}
+	if len(batch) == 0 {
+		return values, errs
+	}
+	res, err := s.execute(context.Background(), request{Batch: batch}, false)
+	if err == nil && len(res.Batch) != len(batch) {
+		err = errors.New("goscript: the script returned the wrong number of batch results")
+	}
+	if err != nil {
+		for _, i := range indexes {
+			errs[i] = err
+		}
+		return values, errs
+	}
+	for j, i := range indexes {
+		values[i], errs[i] = res.Batch[j].Value, batchErr(res.Batch[j])
+	}
+	return values, errs
+}
+
+// batchErr gets the error for a call in a batch from its response.
+func batchErr(res response) error {
+	switch {
+	case res.Err != "":
+		return errors.New(res.Err)
+	case res.ErrorText != "":
+		return errors.New(res.ErrorText)
+	}
+	return callErr(res, nil)
+}
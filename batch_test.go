@@ -0,0 +1,43 @@
+package goscript
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestExecuteBatch(t *testing.T) {
+	for _, codec := range []Codec{GobCodec, JSONCodec} {
+		is := is.New(t)
+		script := New(`
+func goscript(n int) (int, error) {
+	if n < 0 {
+		panic("negative")
+	}
+	return n * n, nil
+}
+`, WithCodec(codec))
+		defer script.Close()
+		argsList := make([][]interface{}, 1000)
+		for i := range argsList {
+			argsList[i] = []interface{}{i}
+		}
+		values, errs := script.ExecuteBatch(argsList)
+		is.Equal(len(values), len(argsList))
+		for i := range argsList {
+			is.NoErr(errs[i]) // ExecuteBatch
+			is.Equal(fmt.Sprint(values[i]), fmt.Sprint(i*i))
+		}
+
+		values, errs = script.ExecuteBatch([][]interface{}{{3}, {-1}, {1, 2}, {4}})
+		is.Equal(fmt.Sprint(values[0]), "9")
+		is.NoErr(errs[0])
+		var panicErr PanicError
+		is.True(errors.As(errs[1], &panicErr))
+		is.Equal(errs[2].Error(), "goscript: got 2 arguments, want 1 (int)")
+		is.Equal(fmt.Sprint(values[3]), "16")
+		is.NoErr(errs[3])
+	}
+}
@@ -0,0 +1,195 @@
+package goscript
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Run compiles the script, calls it once with the specified
+// arguments, and shuts it down again, for one-off calls where
+// managing a Script isn't worth it.
+// Compiled scripts are cached in the user's cache directory (see
+// os.UserCacheDir), so running the same script again doesn't
+// compile it again.
+func Run(script string, args ...interface{}) (interface{}, error) {
+	prog, err := cachedProgram(script, options{})
+	if err != nil {
+		return nil, err
+	}
+	s := prog.New()
+	defer s.Close()
+	return s.Execute(args...)
+}
+
+// CacheKey gets the key that the compiled script is cached under by
+// Run. It covers everything that affects compilation: the script,
+// the options, the version of Go, and the environment variables that
+// control the build. Scripts with the same key share a binary.
+func CacheKey(script string, opts ...Option) string {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	_, src, err := newProgram(script, o)
+	if err != nil {
+		// the script won't compile, so there is nothing to share
+		src = []byte(script)
+	}
+	return cacheKey(src, o)
+}
+
+// cacheKey hashes the generated source along with the other inputs
+// to the build.
+func cacheKey(src []byte, opts options) string {
+	h := sha256.New()
+	h.Write(src)
+	fmt.Fprintf(h, "\x00%q\x00%s", opts.buildFlags(), goVersion(opts.goCommand()))
+	for _, sf := range opts.sourceFiles {
+		fmt.Fprintf(h, "\x00%s\x00%s", sf.name, sf.src)
+	}
+	if opts.module != nil {
+		fmt.Fprintf(h, "\x00%s\x00%q", opts.module.path, opts.module.requires)
+	}
+	for _, name := range buildEnv {
+		fmt.Fprintf(h, "\x00%s=%s", name, os.Getenv(name))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// buildEnv lists the environment variables that affect go build.
+var buildEnv = []string{"GOOS", "GOARCH", "GOARM", "GOAMD64", "CGO_ENABLED", "GOFLAGS", "GOEXPERIMENT", "GOTOOLCHAIN"}
+
+var (
+	goVersionsLock sync.Mutex
+	// goVersions holds the output of go version for each go
+	// command.
+	goVersions = make(map[string]string)
+)
+
+// goVersion gets the output of go version for the go command, which
+// describes the toolchain scripts are compiled with.
+func goVersion(goCmd string) string {
+	goVersionsLock.Lock()
+	defer goVersionsLock.Unlock()
+	if v, ok := goVersions[goCmd]; ok {
+		return v
+	}
+	var v string
+	if out, err := exec.Command(goCmd, "version").Output(); err == nil {
+		v = strings.TrimSpace(string(out))
+	}
+	goVersions[goCmd] = v
+	return v
+}
+
+// WithNoCache makes the Cached ExecMode compile the script even if it
+// is already in the cache, replacing the cached binary, for when the
+// cache is suspected to be stale.
+func WithNoCache() Option {
+	return func(o *options) {
+		o.noCache = true
+	}
+}
+
+// InvalidateCache removes the cached binary for the script compiled
+// with the options, if there is one, so it is compiled again next
+// time it is run.
+func InvalidateCache(script string, opts ...Option) error {
+	dir, err := cacheDir()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(dir, CacheKey(script, opts...)+exeSuffix))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ClearCache removes all the compiled scripts from the cache.
+func ClearCache() error {
+	dir, err := cacheDir()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// cacheDir gets the directory compiled scripts are cached in.
+func cacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "goscript"), nil
+}
+
+// cachedProgram gets a Program for the script from the cache,
+// compiling it first if it isn't there.
+// The binary is named after its CacheKey.
+func cachedProgram(script string, opts options) (*Program, error) {
+	prog, src, err := newProgram(script, opts)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := cacheDir()
+	if err != nil {
+		return nil, err
+	}
+	if opts.vet {
+		if prog.vetWarnings, err = vetScript(src, opts); err != nil {
+			return nil, err
+		}
+	}
+	key := cacheKey(src, opts)
+	prog.binary = filepath.Join(dir, key+exeSuffix)
+	if _, err := os.Stat(prog.binary); err == nil && !opts.noCache {
+		return prog, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	// build in a temporary directory and rename the binary into
+	// place, so a partly written binary is never run
+	tmp, err := ioutil.TempDir(dir, key+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	binary := filepath.Join(tmp, "goscript"+exeSuffix)
+	if err := build(src, binary, opts); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(binary, prog.binary); err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+// startCached starts the script from the cache, compiling it first if
+// it isn't there. If a cached binary can't be run, such as when it has
+// been corrupted, it is compiled again.
+func startCached(script string, opts options) (*process, error) {
+	prog, err := cachedProgram(script, opts)
+	if err != nil {
+		return nil, err
+	}
+	p, err := startProgramProcess(prog)
+	var pathErr *os.PathError
+	if err == nil || opts.noCache || !errors.As(err, &pathErr) {
+		return p, err
+	}
+	opts.noCache = true
+	if prog, err = cachedProgram(script, opts); err != nil {
+		return nil, err
+	}
+	return startProgramProcess(prog)
+}
@@ -0,0 +1,63 @@
+package goscript
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"io"
+)
+
+// Codec is the encoding used for the values passed to and from the
+// script.
+type Codec int
+
+const (
+	// GobCodec encodes values with encoding/gob, which is the
+	// default. Values keep their Go types, but any types other than
+	// the basic types must be registered with gob.Register (or see
+	// WithAutoRegister and RegisterTypeAs).
+	GobCodec Codec = iota
+	// JSONCodec encodes values with encoding/json, so nothing needs
+	// registering. Arguments are decoded into the types of the
+	// goscript parameters, but return values are decoded as JSON
+	// would decode them into an interface{}: numbers become float64,
+	// objects (including structs) map[string]interface{}, and arrays
+	// []interface{}. Errors returned by the script keep only their
+	// messages.
+	// Requests and responses are written to the pipe as one JSON
+	// object per line, so they can be read when debugging, or by
+	// tools other than goscript. As with gob, unexported struct
+	// fields aren't sent.
+	JSONCodec
+)
+
+// WithCodec sets the encoding used for the values passed to and from
+// the script.
+func WithCodec(c Codec) Option {
+	return func(o *options) {
+		o.codec = c
+	}
+}
+
+// encoder and decoder are implemented by the gob and json encoders
+// and decoders.
+type encoder interface {
+	Encode(v interface{}) error
+}
+
+type decoder interface {
+	Decode(v interface{}) error
+}
+
+func newEncoder(c Codec, w io.Writer) encoder {
+	if c == JSONCodec {
+		return json.NewEncoder(w)
+	}
+	return gob.NewEncoder(w)
+}
+
+func newDecoder(c Codec, r io.Reader) decoder {
+	if c == JSONCodec {
+		return json.NewDecoder(r)
+	}
+	return gob.NewDecoder(r)
+}
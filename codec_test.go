@@ -0,0 +1,54 @@
+package goscript
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestJSONCodec(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import "errors"
+
+type point struct {
+	X, Y int
+}
+
+func goscript(in map[string]interface{}, p point, fail bool) (map[string]interface{}, error) {
+	if fail {
+		return nil, errors.New("failed")
+	}
+	in["point"] = p
+	return in, nil
+}
+`, WithCodec(JSONCodec))
+	defer script.Close()
+	in := map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": map[string]interface{}{
+				"c": []interface{}{1, "two", 3.5, nil},
+			},
+			"d": true,
+		},
+	}
+	out, err := script.Execute(in, struct{ X, Y int }{1, 2}, false)
+	is.NoErr(err) // Execute
+	is.Equal(out, map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": map[string]interface{}{
+				"c": []interface{}{1.0, "two", 3.5, nil},
+			},
+			"d": true,
+		},
+		"point": map[string]interface{}{"X": 1.0, "Y": 2.0},
+	})
+
+	_, err = script.Execute(in, struct{ X, Y int }{}, true)
+	is.True(err != nil)
+	is.Equal(err.Error(), "failed")
+
+	_, err = script.Execute(in, "not a point", false)
+	is.True(err != nil)
+	is.Equal(err.Error(), "goscript: argument p: json: cannot unmarshal string into Go value of type main.point")
+}
@@ -0,0 +1,97 @@
+package goscript
+
+import (
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strconv"
+)
+
+// WithAllowedCommands restricts the commands the script can run with
+// os/exec to those named, which are matched exactly against the name
+// passed to exec.Command or exec.CommandContext. Other commands fail
+// to start, with an error saying they aren't allowed.
+// This is done by rewriting the script's calls to exec.Command and
+// exec.CommandContext to go through a wrapper that goscript provides,
+// not by the operating system, so it doesn't stop scripts that
+// start processes in other ways, such as with os.StartProcess or
+// syscall.Exec. Use it to catch mistakes, not to contain hostile
+// scripts.
+func WithAllowedCommands(cmds ...string) Option {
+	return func(o *options) {
+		o.restrictCommands = true
+		o.allowedCommands = append(o.allowedCommands, cmds...)
+	}
+}
+
+// execAlias is what the script's import of os/exec is renamed to
+// when commands are restricted.
+const execAlias = "goscriptosexec"
+
+// edit replaces the source between two offsets.
+type edit struct {
+	start, end int
+	text       string
+}
+
+// restrictCommands rewrites the script's uses of os/exec so commands
+// are made by the harness's wrappers, and reports whether the script
+// uses os/exec at all.
+// Only identifiers are replaced, so lines stay where they were.
+func restrictCommands(script string) (string, bool, error) {
+	const header = "package main\n"
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "goscript.go", header+script, 0)
+	if err != nil {
+		// let the compiler report it
+		return script, false, nil
+	}
+	offset := func(pos token.Pos) int {
+		return fset.Position(pos).Offset - len(header)
+	}
+	var edits []edit
+	name := ""
+	for _, spec := range f.Imports {
+		if path, _ := strconv.Unquote(spec.Path.Value); path != "os/exec" {
+			continue
+		}
+		name = "exec"
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if name == "_" || name == "." {
+			return "", false, errors.New("goscript: os/exec must be imported by name when commands are restricted")
+		}
+		edits = append(edits, edit{offset(spec.Pos()), offset(spec.End()), execAlias + ` "os/exec"`})
+	}
+	if name == "" {
+		return script, false, nil
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		x, ok := sel.X.(*ast.Ident)
+		if !ok || x.Name != name || x.Obj != nil {
+			// not the package, or shadowed by a declaration
+			return true
+		}
+		switch sel.Sel.Name {
+		case "Command", "CommandContext":
+			edits = append(edits, edit{offset(sel.Pos()), offset(sel.End()), "goscript" + sel.Sel.Name})
+		default:
+			edits = append(edits, edit{offset(x.Pos()), offset(x.End()), execAlias})
+		}
+		return true
+	})
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start > edits[j].start
+	})
+	for _, e := range edits {
+		script = script[:e.start] + e.text + script[e.end:]
+	}
+	return script, true, nil
+}
@@ -0,0 +1,77 @@
+package goscript
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// Compression is how the protocol stream between the caller and the
+// script is compressed.
+type Compression int
+
+const (
+	// NoCompression sends values uncompressed, which is the
+	// default.
+	NoCompression Compression = iota
+	// CompressGzip compresses the stream in each direction with
+	// gzip, flushing it after each message. It costs CPU time, but
+	// makes large, repetitive values much smaller on the pipe.
+	CompressGzip
+)
+
+// WithCompression compresses the values passed to and from the script.
+// Both ends of the stream are set up when the script is generated, and
+// the script says which compression it uses when it is ready, so a
+// mismatch fails at start rather than corrupting the stream.
+func WithCompression(c Compression) Option {
+	return func(o *options) {
+		o.compression = c
+	}
+}
+
+// readyMarker gets the marker written by scripts using c once they
+// are ready.
+func (c Compression) readyMarker() string {
+	if c == CompressGzip {
+		return "\x00goscript:ready:gzip\n"
+	}
+	return readyMarker
+}
+
+// flushEncoder flushes the compressor after each value, so the other
+// end can decode it straight away.
+type flushEncoder struct {
+	enc encoder
+	zw  *gzip.Writer
+}
+
+func (e flushEncoder) Encode(v interface{}) error {
+	if err := e.enc.Encode(v); err != nil {
+		return err
+	}
+	return e.zw.Flush()
+}
+
+// newStreamEncoder makes an encoder for values sent with the
+// compression.
+func newStreamEncoder(codec Codec, c Compression, w io.Writer) encoder {
+	if c == CompressGzip {
+		zw := gzip.NewWriter(w)
+		return flushEncoder{enc: newEncoder(codec, zw), zw: zw}
+	}
+	return newEncoder(codec, w)
+}
+
+// newStreamDecoder makes a decoder for values received with the
+// compression. It blocks until the start of the compressed stream
+// has been received.
+func newStreamDecoder(codec Codec, c Compression, r io.Reader) (decoder, error) {
+	if c == CompressGzip {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return newDecoder(codec, zr), nil
+	}
+	return newDecoder(codec, r), nil
+}
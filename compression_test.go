@@ -0,0 +1,48 @@
+package goscript
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+const echoScript = `
+func goscript(s string) (string, error) {
+	return s, nil
+}
+`
+
+func TestCompression(t *testing.T) {
+	for _, codec := range []Codec{GobCodec, JSONCodec} {
+		is := is.New(t)
+		script := New(echoScript, WithCompression(CompressGzip), WithCodec(codec))
+		defer script.Close()
+		msg := strings.Repeat("goscript ", (1<<20)/len("goscript "))
+		for i := 0; i < 2; i++ {
+			v, err := script.Execute(msg)
+			is.NoErr(err) // Execute
+			is.Equal(v, msg)
+		}
+	}
+}
+
+func BenchmarkCompression(b *testing.B) {
+	msg := strings.Repeat("goscript ", (1<<20)/len("goscript "))
+	for name, c := range map[string]Compression{"none": NoCompression, "gzip": CompressGzip} {
+		b.Run(name, func(b *testing.B) {
+			script := New(echoScript, WithCompression(c))
+			defer script.Close()
+			if _, err := script.Execute("warm up"); err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(msg)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := script.Execute(msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package goscript
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func setCredential(cmd *exec.Cmd, c credential) error {
+	return errors.New("goscript: credentials are not supported on this platform")
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package goscript
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func setCredential(cmd *exec.Cmd, c credential) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: c.uid, Gid: c.gid}
+	return nil
+}
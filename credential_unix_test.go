@@ -0,0 +1,48 @@
+//go:build unix
+
+package goscript
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestCredential(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("switching user needs root")
+	}
+	is := is.New(t)
+	f, err := ioutil.TempFile("", "goscript-secret")
+	is.NoErr(err) // TempFile
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("secret")
+	is.NoErr(err) // WriteString
+	is.NoErr(f.Close())
+	is.NoErr(os.Chmod(f.Name(), 0600))
+
+	// nobody
+	script := New(`
+import (
+	"fmt"
+	"io/ioutil"
+	"syscall"
+)
+
+func goscript(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Sprintf("uid %d: %v", syscall.Getuid(), err), nil
+	}
+	return string(b), nil
+}
+`, WithCredential(65534, 65534))
+	defer script.Close()
+	out, err := script.Execute(f.Name())
+	is.NoErr(err) // Execute
+	is.True(strings.HasPrefix(out.(string), "uid 65534: "))
+	is.True(strings.Contains(out.(string), "permission denied"))
+}
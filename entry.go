@@ -0,0 +1,125 @@
+package goscript
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/token"
+	"strings"
+)
+
+// Call executes one of the script's other entry points, with the
+// specified arguments. Scripts can declare any number of functions
+// named goscript_ followed by a name, alongside or instead of func
+// goscript, which are called by that name:
+//
+//	func goscript_encode(s string) (string, error)
+//	func goscript_decode(s string) (string, error)
+//
+//	encoded, err := script.Call("encode", "hello")
+//
+// Entry points are called in the same way as func goscript, except
+// that they aren't given a progress function, context or deadline.
+func (s *Script) Call(name string, args ...interface{}) (interface{}, error) {
+	res, err := s.execute(context.Background(), request{Func: name, Args: args}, false)
+	if err != nil {
+		return nil, err
+	}
+	return res.Value, res.Error
+}
+
+// WithEntryPoint sets the name of the function Execute calls, in place
+// of goscript, so that existing functions can be used as scripts
+// without renaming them:
+//
+//	script := goscript.New(`
+//	func wordCount(s string) (int, error) {
+//		return len(strings.Fields(s)), nil
+//	}
+//	`, goscript.WithEntryPoint("wordCount"))
+//
+// Other entry points are still named goscript_ followed by their name.
+func WithEntryPoint(name string) Option {
+	return func(o *options) {
+		o.entryPoint = name
+	}
+}
+
+// entryName gets the name of the function Execute calls.
+func (o options) entryName() string {
+	if o.entryPoint == "" {
+		return "goscript"
+	}
+	return o.entryPoint
+}
+
+// checkEntryName checks the function Execute calls can be named name.
+func checkEntryName(name string) error {
+	if !token.IsIdentifier(name) || name == "main" || name == "init" || strings.HasPrefix(name, "goscript_") {
+		return fmt.Errorf("goscript: the entry point can't be named %q", name)
+	}
+	return nil
+}
+
+// errNoGoscript is returned by calls to func goscript in scripts that
+// only have other entry points.
+var errNoGoscript = errors.New("goscript: the script has no func goscript; use Call")
+
+// entryPoint is a func goscript_name declared by a script.
+type entryPoint struct {
+	Name   string
+	Params []arg
+	// ErrorOnly is set if the entry point returns only an error.
+	ErrorOnly bool
+}
+
+// ArgsList gets the parameter names, for calling the function.
+func (e entryPoint) ArgsList() string {
+	names := make([]string, len(e.Params))
+	for i, param := range e.Params {
+		names[i] = param.Argname()
+	}
+	return strings.Join(names, ", ")
+}
+
+// entryPoints finds the script's entry points other than the function
+// named entry, which Execute calls.
+func entryPoints(script, entry string) ([]entryPoint, error) {
+	funcs, _ := parseScript(script, entry)
+	var entries []entryPoint
+	for _, sf := range funcs.entries {
+		e := entryPoint{Name: sf.name, Params: sf.params, ErrorOnly: sf.results == 1}
+		if len(sf.typeParams) > 0 {
+			return nil, fmt.Errorf("goscript: func goscript_%s can't have type parameters", e.Name)
+		}
+		for _, param := range e.Params {
+			if param.Provided() {
+				return nil, fmt.Errorf("goscript: func goscript_%s can't take %s %s, which is only provided to func goscript", e.Name, param.Name, param.Typ)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// declaresGoscript reports whether the script declares the function
+// named entry, usually func goscript, which it needn't if it has other
+// entry points.
+func declaresGoscript(script, entry string) bool {
+	funcs, _ := parseScript(script, entry)
+	return funcs.goscript != nil
+}
+
+// entryParams gets the parameters of the entry point with the name,
+// or of func goscript if name is empty, and whether there is one.
+func (p *process) entryParams(name string) ([]arg, bool) {
+	if name == "" {
+		return p.params, true
+	}
+	for _, e := range p.entries {
+		if e.Name == name {
+			return e.Params, true
+		}
+	}
+	return nil, false
+}
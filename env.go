@@ -0,0 +1,47 @@
+package goscript
+
+import (
+	"os"
+	"strconv"
+)
+
+// WithEnv sets the environment of the script process, as key=value
+// strings, in place of the caller's, which it inherits by default.
+// An empty env runs the script with no environment variables.
+// Setting it makes GoRun behave as GoBuild, so that the go tool still
+// runs with the caller's environment (see WithExecMode).
+func WithEnv(env []string) Option {
+	return func(o *options) {
+		o.envSet = true
+		o.baseEnv = append([]string{}, env...)
+	}
+}
+
+// WithEnvVar adds an environment variable to the script process's
+// environment, on top of the caller's, or of the environment set with
+// WithEnv. Like WithEnv, it makes GoRun behave as GoBuild.
+func WithEnvVar(key, value string) Option {
+	return func(o *options) {
+		o.envVars = append(o.envVars, key+"="+value)
+	}
+}
+
+// env gets the environment of the script process, or nil if it
+// inherits the caller's.
+func (o options) env() []string {
+	if !o.envSet && len(o.envVars) == 0 && !o.cleanEnv && o.memoryLimit == 0 {
+		return nil
+	}
+	env := []string{}
+	switch {
+	case o.envSet:
+		env = append(env, o.baseEnv...)
+	case !o.cleanEnv:
+		env = os.Environ()
+	}
+	env = append(env, o.envVars...)
+	if o.memoryLimit > 0 {
+		env = append(env, "GOMEMLIMIT="+strconv.FormatInt(o.memoryLimit, 10))
+	}
+	return env
+}
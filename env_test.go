@@ -0,0 +1,44 @@
+package goscript
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestEnv(t *testing.T) {
+	is := is.New(t)
+	t.Setenv("GOSCRIPT_CALLER", "caller")
+	src := `
+import "os"
+
+func goscript(key string) (string, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return "unset", nil
+	}
+	return v, nil
+}
+`
+	script := New(src, WithEnvVar("GOSCRIPT_GREETING", "hello"))
+	defer script.Close()
+	v, err := script.Execute("GOSCRIPT_GREETING")
+	is.NoErr(err) // Execute
+	is.Equal(v, "hello")
+	// the caller's environment is inherited
+	v, err = script.Execute("GOSCRIPT_CALLER")
+	is.NoErr(err) // Execute
+	is.Equal(v, "caller")
+
+	script = New(src, WithEnv([]string{"GOSCRIPT_GREETING=hi"}), WithEnvVar("GOSCRIPT_NAME", "mat"))
+	defer script.Close()
+	v, err = script.Execute("GOSCRIPT_GREETING")
+	is.NoErr(err) // Execute
+	is.Equal(v, "hi")
+	v, err = script.Execute("GOSCRIPT_NAME")
+	is.NoErr(err) // Execute
+	is.Equal(v, "mat")
+	v, err = script.Execute("GOSCRIPT_CALLER")
+	is.NoErr(err) // Execute
+	is.Equal(v, "unset")
+}
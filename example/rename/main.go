@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"strings"
 
 	"github.com/matryer/goscript"
@@ -35,7 +36,10 @@ func goscript(filename string) (string, error) {
 		return out, nil
 }
 	`
-	gs := goscript.New(fullscript)
+	gs, err := goscript.NewScript(fullscript, goscript.WithAllowedImports(allowedImports...))
+	if err != nil {
+		log.Fatalln("goscript:", err)
+	}
 	defer gs.Close()
 	for _, file := range files {
 		newfilename, err := gs.Execute(file)
@@ -47,7 +51,10 @@ func goscript(filename string) (string, error) {
 	}
 }
 
-var disallowedStrings = []string{"import", "func"}
+// disallowedStrings keeps the script inside the body of func
+// goscript; which packages it can use is limited by
+// WithAllowedImports.
+var disallowedStrings = []string{"func"}
 
 func checkDisallowed(script string) error {
 	loscript := strings.ToLower(script)
@@ -59,14 +66,14 @@ func checkDisallowed(script string) error {
 	return nil
 }
 
-var allowedImports = []string{"filepath", "strings"}
+var allowedImports = []string{"path/filepath", "strings"}
 
 // imports generates a list of allowedImports if they are used in
 // the script.
 func imports(script string) string {
 	var imports string
 	for _, pkg := range allowedImports {
-		if strings.Contains(script, fmt.Sprintf("%s.", pkg)) {
+		if strings.Contains(script, fmt.Sprintf("%s.", path.Base(pkg))) {
 			imports += fmt.Sprintf("%q\n", pkg)
 		}
 	}
@@ -0,0 +1,107 @@
+package goscript
+
+import (
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// WithTypeParam gives the type that a type parameter of a generic
+// func goscript is instantiated with, so that scripts copied from
+// generic helpers can be run:
+//
+//	script := goscript.New(`
+//	func goscript[T any](items []T) (T, error) {
+//		var last T
+//		if len(items) > 0 {
+//			last = items[len(items)-1]
+//		}
+//		return last, nil
+//	}
+//	`, goscript.WithTypeParam("T", "string"))
+//
+// typ is written as it would be in the script, and must satisfy the
+// type parameter's constraint. Each of the function's type parameters
+// must be given a type.
+func WithTypeParam(name, typ string) Option {
+	return func(o *options) {
+		if o.typeArgs == nil {
+			o.typeArgs = make(map[string]string)
+		}
+		o.typeArgs[name] = typ
+	}
+}
+
+// instantiate gives the function's type parameters the types in
+// typeArgs, substituting them in its parameters, and gets the type
+// arguments to call it with, such as [int, string], or an empty string
+// if it isn't generic. The function is named name, for errors.
+func (sf *scriptFunc) instantiate(name string, typeArgs map[string]string) (string, error) {
+	declared := make(map[string]bool, len(sf.typeParams))
+	var types []string
+	for _, param := range sf.typeParams {
+		declared[param] = true
+		typ, ok := typeArgs[param]
+		if !ok {
+			return "", fmt.Errorf("goscript: func %s has type parameter %s; give its type with WithTypeParam(%q, type)", name, param, param)
+		}
+		types = append(types, typ)
+	}
+	var unknown []string
+	for param := range typeArgs {
+		if !declared[param] {
+			unknown = append(unknown, param)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return "", fmt.Errorf("goscript: func %s has no type parameter %s", name, strings.Join(unknown, ", "))
+	}
+	if len(types) == 0 {
+		return "", nil
+	}
+	for i := range sf.params {
+		sf.params[i].Typ = substituteTypeParams(sf.params[i].Typ, typeArgs)
+	}
+	return "[" + strings.Join(types, ", ") + "]", nil
+}
+
+// substituteTypeParams replaces the type parameters in typ with the
+// types in typeArgs.
+func substituteTypeParams(typ string, typeArgs map[string]string) string {
+	var sc scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(typ))
+	sc.Init(file, []byte(typ), nil, 0)
+	var b strings.Builder
+	last, prev := 0, token.ILLEGAL
+	for {
+		pos, tok, lit := sc.Scan()
+		if tok == token.EOF {
+			break
+		}
+		// identifiers after a dot are qualified, such as T in pkg.T
+		if concrete, ok := typeArgs[lit]; ok && tok == token.IDENT && prev != token.PERIOD {
+			offset := file.Offset(pos)
+			b.WriteString(typ[last:offset])
+			b.WriteString(concrete)
+			last = offset + len(lit)
+		}
+		prev = tok
+	}
+	b.WriteString(typ[last:])
+	return b.String()
+}
+
+// typeArgsList gets the type arguments to call the function named
+// entry with, if it is generic.
+func typeArgsList(script, entry string, typeArgs map[string]string) string {
+	funcs, _ := parseScript(script, entry)
+	if funcs.goscript == nil {
+		return ""
+	}
+	list, _ := funcs.goscript.instantiate(entry, typeArgs)
+	return list
+}
@@ -0,0 +1,53 @@
+package goscript
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestTypeParam(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript[K comparable, V any](m map[K]V, key K) (V, error) {
+	return m[key], nil
+}
+`, WithTypeParam("K", "string"), WithTypeParam("V", "int"))
+	defer script.Close()
+	v, err := script.Execute(map[string]int{"a": 1, "b": 2}, "b")
+	is.NoErr(err) // Execute
+	is.Equal(v, 2)
+}
+
+func TestTypeParamMissing(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript[T any](items []T) (T, error) {
+	return items[0], nil
+}
+`)
+	defer script.Close()
+	_, err := script.Execute([]string{"a"})
+	is.True(err != nil) // no type for T
+	is.True(strings.Contains(err.Error(), `WithTypeParam("T", type)`))
+
+	script = New(`
+func goscript(items []string) (string, error) {
+	return items[0], nil
+}
+`, WithTypeParam("T", "string"))
+	defer script.Close()
+	_, err = script.Execute([]string{"a"})
+	is.True(err != nil) // not generic
+	is.Equal(err.Error(), "goscript: func goscript has no type parameter T")
+}
+
+func TestSubstituteTypeParams(t *testing.T) {
+	is := is.New(t)
+	typeArgs := map[string]string{"T": "int", "K": "string"}
+	is.Equal(substituteTypeParams("[]T", typeArgs), "[]int")
+	is.Equal(substituteTypeParams("map[K][]T", typeArgs), "map[string][]int")
+	is.Equal(substituteTypeParams("func(T) pkg.T", typeArgs), "func(int) pkg.T")
+	is.Equal(substituteTypeParams("Tree", typeArgs), "Tree")
+}
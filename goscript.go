@@ -3,355 +3,3118 @@ package goscript
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/gob"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"go/ast"
+	gobuild "go/build"
+	"go/parser"
+	"go/scanner"
+	"go/token"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
+	"time"
 )
 
 // Error represents a Goscript error.
 type Error struct {
 	Err    error
 	Stderr string
+	// ExitCode is the exit code of the script process, for errors
+	// caused by it exiting, or -1 if it was killed by a signal.
+	ExitCode int
+
+	// exited is set for errors caused by the script process
+	// exiting.
+	exited bool
+	// format is the formatter set with WithErrorFormatter.
+	format func(Error) string
 }
 
 func (e Error) Error() string {
+	if e.format != nil {
+		f := e.format
+		e.format = nil
+		return f(e)
+	}
+	switch {
+	case e.exited:
+		exit := fmt.Sprintf("goscript: the script exited with code %d", e.ExitCode)
+		var exitErr *exec.ExitError
+		if !errors.As(e.Err, &exitErr) || !exitErr.Exited() {
+			// the error says more than the code, such as the
+			// signal that killed the script
+			exit += fmt.Sprintf(": %v", e.Err)
+		}
+		if e.Stderr == "" {
+			return exit
+		}
+		return e.Stderr + "\n" + exit
+	case e.Stderr == "" && e.Err != nil:
+		// such as when the go command couldn't be run
+		return e.Err.Error()
+	}
 	return fmt.Sprintf("%s", e.Stderr)
 }
 
-// Script represents a script.
-type Script struct {
-	err         error
-	scriptFile  string
-	scriptLines int
-	cmd         *exec.Cmd
+// Unwrap gets the underlying error.
+func (e Error) Unwrap() error {
+	return e.Err
+}
 
-	executeLock sync.Mutex
+// PanicError is returned when the goscript function, or an entry
+// point run by Call, panics.
+// The script keeps running, and can be executed again.
+type PanicError struct {
+	// Value is the value passed to panic, formatted as a string.
+	Value string
+	// Stack is the stack trace of the panicking goroutine. Lines of
+	// the script appear as goscript:line.
+	Stack string
+}
 
-	stdin         io.WriteCloser
-	stdinencoder  *gob.Encoder
-	stdout        io.ReadCloser
-	stdoutdecoder *gob.Decoder
-	stderr        io.ReadCloser
+func (e PanicError) Error() string {
+	return "goscript: panic: " + e.Value
 }
 
-// New makes a new running Script.
-// Caller must call Close.
-func New(script string) *Script {
-	s := &Script{
-		err: scriptHarnessTemplateErr,
+// Option configures a Script.
+type Option func(*options)
+
+type options struct {
+	cpuAffinity      []int
+	stdoutResult     bool
+	onCrash          func(err error, stderr string)
+	autoRegister     bool
+	seccomp          *SeccompProfile
+	coerceArgs       bool
+	maxExecutions    int
+	extraFiles       []*os.File
+	stdin            *stdinFeeder
+	buildTags        []string
+	ldflags          string
+	extraBuildFlags  []string
+	goBinary         string
+	entryPoint       string
+	typeArgs         map[string]string
+	workDir          string
+	module           *module
+	formatError      func(Error) string
+	credential       *credential
+	sourceFiles      []sourceFile
+	startTimeout     time.Duration
+	codec            Codec
+	buildProgress    func(line string)
+	vet              bool
+	strictArgs       bool
+	mode             ExecMode
+	redact           func(string) string
+	executeRetries   int
+	autoRestart      bool
+	noCache          bool
+	strictPrecompile bool
+	restrictCommands bool
+	allowedCommands  []string
+	restrictImports  bool
+	allowedImports   []string
+	leakThreshold    int
+	onLeak           func(growth int)
+	cleanEnv         bool
+	memoryLimit      int64
+	limits           *ResourceLimits
+	observer         Observer
+	envSet           bool
+	baseEnv          []string
+	envVars          []string
+	compression      Compression
+}
+
+// WithCPUAffinity pins the script process to the specified CPU cores.
+// This is useful for benchmarking or latency-sensitive scripts where
+// scheduler jitter matters.
+// Only Linux is supported; on other platforms New fails with an error.
+// Affinity is applied to the process as soon as it has started, so it
+// is best-effort for any threads created during that brief window.
+func WithCPUAffinity(cpus []int) Option {
+	return func(o *options) {
+		o.cpuAffinity = cpus
 	}
-	if s.err != nil {
-		return s
+}
+
+// WithStdoutResult makes Execute return whatever the script writes to
+// stdout during the call as a string, instead of a return value.
+// In this mode the goscript function returns only an error:
+//
+//	func goscript(name string) error {
+//		fmt.Print("Hello " + name)
+//		return nil
+//	}
+//
+// The script's stdout is a pipe of its own, and responses are sent to
+// the host on another, after any extra files (see WithExtraFiles) and
+// the pipe requests are read from, if there is one (see WithStdin), so
+// everything written to stdout is captured, including by programs the
+// script runs while it is called. What is written between calls is
+// sent to the writer set with SetOutput, if any, once the next call
+// starts.
+// On Windows, only writes through os.Stdout are captured.
+func WithStdoutResult() Option {
+	return func(o *options) {
+		o.stdoutResult = true
 	}
-	var args []arg
-	if s.scriptLines, args, s.err = processScript(script); s.err != nil {
-		return s
+}
+
+// stdoutPipe reports whether the script's stdout is a pipe of its own
+// that it reads back for WithStdoutResult, with responses on another
+// pipe, which needs extra files, so isn't done on Windows.
+func (o options) stdoutPipe() bool {
+	return o.stdoutResult && runtime.GOOS != "windows"
+}
+
+// OnCrash registers a function that is called if the script process
+// exits unexpectedly.
+// The error describes how the process exited (an *exec.ExitError carries
+// the exit code), and stderr contains everything it wrote to stderr.
+// The function is called from a background goroutine, and not for
+// processes shut down by Close or Reload.
+func OnCrash(fn func(err error, stderr string)) Option {
+	return func(o *options) {
+		o.onCrash = fn
 	}
-	if s.scriptFile, s.err = createScriptFile(script, args); s.err != nil {
-		return s
+}
+
+// WithGoroutineLeakCheck counts the goroutines in the script process
+// before and after each call, and calls fn with the growth if more
+// than threshold goroutines were left running by the call.
+// It helps catch scripts that leak goroutines during development.
+// The function is called before the call returns, so goroutines that
+// finish shortly after the script returns may still be counted.
+func WithGoroutineLeakCheck(threshold int, fn func(growth int)) Option {
+	return func(o *options) {
+		o.leakThreshold = threshold
+		o.onLeak = fn
 	}
-	s.cmd = exec.Command("go", "run", s.scriptFile)
-	if s.stdin, s.err = s.cmd.StdinPipe(); s.err != nil {
-		return s
+}
+
+// WithAutoRegister makes Execute register the named struct types of
+// its arguments with gob, so they don't need registering up front.
+// The script must declare a struct type with the same name, which is
+// registered in the script process under the caller's gob name.
+// Each new type costs one extra round trip the first time it is seen
+// by the script process.
+func WithAutoRegister() Option {
+	return func(o *options) {
+		o.autoRegister = true
 	}
-	s.stdinencoder = gob.NewEncoder(s.stdin)
-	if s.stdout, s.err = s.cmd.StdoutPipe(); s.err != nil {
-		return s
+}
+
+// WithArgCoercion makes Execute parse string arguments into the types
+// of the goscript parameters they are passed for, which is useful when
+// arguments come from the command line.
+// Parameters of type bool, and the sized and unsized int, uint and
+// float types are supported. Other arguments are passed unchanged.
+func WithArgCoercion() Option {
+	return func(o *options) {
+		o.coerceArgs = true
 	}
-	s.stdoutdecoder = gob.NewDecoder(s.stdout)
-	if s.stderr, s.err = s.cmd.StderrPipe(); s.err != nil {
-		return s
+}
+
+// WithStrictArgs makes Execute reject arguments whose types aren't
+// exactly the types of the goscript parameters, rather than leaving
+// mismatches to fail (or be converted) in the script. For example,
+// an int32 passed for an int parameter is an error.
+// Types declared in the script match types of the same name in the
+// caller, as they do for gob. Any argument matches an interface{}
+// parameter.
+func WithStrictArgs() Option {
+	return func(o *options) {
+		o.strictArgs = true
 	}
-	if s.err = s.cmd.Start(); s.err != nil {
-		return s
+}
+
+// WithMaxExecutions restarts the script process after every n calls,
+// which stops slow leaks in long-running scripts building up.
+// The script's state is reset by the restart, as if New had been
+// called again. The call that reaches the limit waits for the new
+// process to start before returning.
+func WithMaxExecutions(n int) Option {
+	return func(o *options) {
+		o.maxExecutions = n
 	}
-	var state string
-	if err := s.stdoutdecoder.Decode(&state); err != nil {
-		b, _ := ioutil.ReadAll(s.stderr)
-		output := processOutput(s.scriptLines, b)
-		if s.err = s.cmd.Wait(); s.err != nil {
-			s.err = Error{Err: s.err, Stderr: output}
-		}
-		return s
+}
+
+// WithExecuteRetries makes idempotent calls retry up to n times if
+// the script process exits during the call, for example because it
+// crashed. The process is restarted before each retry, after a short
+// jittered backoff. Calls are only idempotent if they are made with
+// ExecuteContext and a context from ContextWithIdempotent; other calls
+// are never retried, since the script may have had side effects
+// before it exited.
+func WithExecuteRetries(n int) Option {
+	return func(o *options) {
+		o.executeRetries = n
 	}
-	if state != "ready" {
-		s.err = errors.New("goscript failed to start")
+}
+
+// WithAutoRestart makes calls restart the script process if it has
+// crashed, or an earlier call left it unusable, rather than returning
+// ErrPoisoned. A call whose process crashes while it runs is tried
+// once more, so this is only suitable for scripts that can safely be
+// called again.
+// Restarts back off while calls keep failing, and after several in a
+// row without a call succeeding, ErrTooManyRestarts is returned until
+// the script is reloaded.
+func WithAutoRestart() Option {
+	return func(o *options) {
+		o.autoRestart = true
 	}
-	return s
 }
 
-// Execute executes the script with the specified arguments, and
-// returns the response.
-func (s *Script) Execute(args ...interface{}) (interface{}, error) {
-	if s.err != nil {
-		return nil, s.err
+// maxAutoRestarts is how many times in a row WithAutoRestart restarts
+// a script before giving up on it.
+const maxAutoRestarts = 5
+
+// ErrTooManyRestarts is returned by calls to a Script set up
+// WithAutoRestart once its process has been restarted too many times
+// without a call succeeding.
+var ErrTooManyRestarts = errors.New("goscript: the script was restarted too many times; Reload it or create it again")
+
+// WithExtraFiles passes open files to the script process, for example
+// to hand it a connection that was accepted by the caller.
+// The protocol with the script uses stdin and stdout, so the files are
+// numbered from 3 in the script, in the order they are given here;
+// scripts can open them with os.NewFile(3, name) and so on.
+// Not supported on Windows.
+func WithExtraFiles(files ...*os.File) Option {
+	return func(o *options) {
+		o.extraFiles = files
 	}
-	if len(args) == 0 {
-		args = []interface{}{}
+}
+
+// WithBuildTags sets build tags for compiling the script, as with
+// go build -tags, which select the extra source files (see
+// WithExtraFile) whose build constraints they satisfy.
+func WithBuildTags(tags ...string) Option {
+	return func(o *options) {
+		o.buildTags = tags
 	}
-	s.executeLock.Lock()
-	defer s.executeLock.Unlock()
-	// send request
-	if err := s.stdinencoder.Encode(args); err != nil {
-		return nil, s.cmdErr(err)
+}
+
+// WithLDFlags sets flags for the linker when compiling the script,
+// as with go build -ldflags.
+func WithLDFlags(flags string) Option {
+	return func(o *options) {
+		o.ldflags = flags
 	}
-	// handle response
-	var res response
-	if err := s.stdoutdecoder.Decode(&res); err != nil {
-		return nil, s.cmdErr(err)
+}
+
+// WithBuildFlags adds flags to the go run or go build command that
+// compiles the script, after those set by other options, such as
+// -race or -gcflags=all=-N, for flags goscript doesn't have an option
+// for. Each flag, with its value, is a separate argument:
+//
+//	goscript.WithBuildFlags("-race", "-gcflags", "all=-N -l")
+//
+// Flags that would stop goscript running the program, such as -o, -n
+// and -exec, can't be used, but others are passed on unchecked, so
+// flags the go command doesn't accept stop the script compiling.
+func WithBuildFlags(flags ...string) Option {
+	return func(o *options) {
+		o.extraBuildFlags = append(o.extraBuildFlags, flags...)
 	}
-	return res.Value, res.Error
 }
 
-func processScript(script string) (int, []arg, error) {
-	n := 0
-	s := bufio.NewScanner(strings.NewReader(script))
-	for s.Scan() {
-		n++
-		trimline := strings.TrimSpace(s.Text())
-		if !strings.HasPrefix(trimline, "func goscript(") {
-			continue
+// reservedBuildFlags are the go command's flags that WithBuildFlags
+// can't use, because goscript must control them to run the program.
+var reservedBuildFlags = map[string]bool{
+	"o":       true,
+	"n":       true,
+	"exec":    true,
+	"modfile": true,
+}
+
+// checkBuildFlags checks the flags added with WithBuildFlags.
+func checkBuildFlags(flags []string) error {
+	for i, flag := range flags {
+		if strings.ContainsAny(flag, "\x00\n") {
+			return fmt.Errorf("goscript: invalid build flag %q", flag)
+		}
+		if !strings.HasPrefix(flag, "-") {
+			if i > 0 && strings.HasPrefix(flags[i-1], "-") && !strings.Contains(flags[i-1], "=") {
+				// the value of the previous flag
+				continue
+			}
+			return fmt.Errorf("goscript: build flag %q must start with -", flag)
+		}
+		name := strings.TrimLeft(flag, "-")
+		if i := strings.Index(name, "="); i >= 0 {
+			name = name[:i]
+		}
+		if reservedBuildFlags[name] {
+			return fmt.Errorf("goscript: build flag %q can't be used", flag)
 		}
-		args := extractArguments(trimline)
-		return n, args, nil
 	}
-	return 0, nil, errors.New("missing func goscript")
+	return nil
 }
 
-type arg struct {
-	Index int
-	Name  string
-	Typ   string
+// WithGoBinary sets the go command used to run and compile the
+// script, such as the path to a particular version of Go. By default,
+// go is found in PATH.
+func WithGoBinary(path string) Option {
+	return func(o *options) {
+		o.goBinary = path
+	}
 }
 
-func (a arg) Variadic() bool {
-	return strings.HasPrefix(a.Typ, "...")
+// WithWorkDir sets the working directory of the script process, which
+// relative paths opened by the script are resolved against. By
+// default, the script runs in the caller's working directory.
+func WithWorkDir(dir string) Option {
+	return func(o *options) {
+		o.workDir = dir
+	}
 }
 
-func (a arg) Argname() string {
-	if a.Variadic() {
-		return a.Name + "..."
+// WithCredential runs the script process as the user and group with
+// the given IDs, so untrusted scripts can be run without the caller's
+// privileges. Only running is done as the user: the script is compiled
+// by the caller first (see WithExecMode), and the directory holding
+// the binary is made readable by everyone.
+// The caller usually needs to be root to switch user.
+// Not supported on Windows.
+func WithCredential(uid, gid uint32) Option {
+	return func(o *options) {
+		o.credential = &credential{uid: uid, gid: gid}
 	}
-	return a.Name
 }
 
-func (a arg) Typename() string {
-	if a.Variadic() {
-		return "[]" + a.Typ[3:]
+// WithStartTimeout limits how long New waits for the script to start,
+// including compiling it when it is run with go run.
+// If the limit is reached, the script process is stopped, and the
+// Script fails with an error wrapping ErrStartTimeout, which says
+// whether the process started and got stuck initializing the script's
+// package-level variables or in its init functions, or never started
+// at all.
+// Without it, New waits up to defaultStartTimeout. If d is negative,
+// New waits for as long as the script takes.
+func WithStartTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.startTimeout = d
 	}
-	return a.Typ
 }
 
-func (a arg) TypenameSingular() string {
-	if a.Variadic() {
-		return a.Typ[3:]
+// defaultStartTimeout is how long New waits for the script to start
+// without WithStartTimeout. It allows for go run compiling the script
+// on a slow machine.
+const defaultStartTimeout = 30 * time.Second
+
+// startLimit gets how long to wait for the script to start, or zero
+// if there is no limit.
+func (o options) startLimit() time.Duration {
+	switch {
+	case o.startTimeout < 0:
+		return 0
+	case o.startTimeout == 0:
+		return defaultStartTimeout
 	}
-	return a.Typ
+	return o.startTimeout
 }
 
-func extractArguments(code string) []arg {
-	segs := strings.Split(code, "(")
-	segs = strings.Split(segs[1], ")")
-	segs = strings.Split(segs[0], ",")
-	if segs[0] == "" {
-		return nil
-	}
-	args := make([]arg, len(segs))
-	for i := range segs {
-		var name, typ string
-		ss := strings.Split(strings.TrimSpace(segs[i]), " ")
-		name = ss[0]
-		if len(ss) > 1 {
-			typ = ss[1]
-			// go back and fill in any missing types
-			for j := i - 1; j >= 0; j-- {
-				if args[j].Typ != "" {
-					break
-				}
-				args[j].Typ = typ
-			}
-		}
-		args[i] = arg{
-			Index: i,
-			Name:  name,
-			Typ:   typ,
-		}
+// ErrStartTimeout is wrapped by the error returned when a script
+// takes longer to start than WithStartTimeout allows.
+var ErrStartTimeout = errors.New("goscript: timed out starting the script")
+
+// ErrPoisoned is returned by calls to a Script whose process can't
+// take any more calls, because an earlier call left it crashed or
+// unable to read the script's responses. Reload the script, or
+// create it again.
+var ErrPoisoned = errors.New("goscript: the script can't take calls after an earlier failure; Reload it or create it again")
+
+// WithExtraFile adds a Go source file, which is compiled along with
+// the script, so helpers can be kept in files of their own. The file
+// must be in package main, and can use anything the script declares,
+// and vice versa.
+// The name is a plain file name ending in .go; it can't be
+// goscript.go, which is the generated file.
+// Not to be confused with WithExtraFiles, which passes open files.
+func WithExtraFile(name, src string) Option {
+	return func(o *options) {
+		o.sourceFiles = append(o.sourceFiles, sourceFile{name: name, src: src})
 	}
-	return args
 }
 
-func createScriptFile(script string, args []arg) (string, error) {
-	dir, err := ioutil.TempDir("", "goscript")
-	if err != nil {
-		return "", err
+// sourceFile is an extra source file to compile with the script.
+type sourceFile struct {
+	name, src string
+}
+
+// credential is the user and group to run the script as.
+type credential struct {
+	uid, gid uint32
+}
+
+// WithErrorFormatter sets how compile and runtime errors (of type
+// Error) are formatted by their Error method, for example as JSON
+// for a front-end that displays them. By default, Error gives the
+// tidied output of the compiler or script.
+// The Error passed to fn formats in the default way.
+func WithErrorFormatter(fn func(Error) string) Option {
+	return func(o *options) {
+		o.formatError = fn
 	}
-	f, err := os.Create(filepath.Join(dir, "goscript.go"))
-	if err != nil {
-		return "", err
+}
+
+// ExecMode is how a script is compiled and run.
+type ExecMode int
+
+const (
+	// GoRun runs the script with go run, which is the default.
+	GoRun ExecMode = iota
+	// GoBuild compiles the script with go build to a temporary
+	// directory, and runs the binary. This avoids the go run
+	// process sitting between the caller and the script.
+	GoBuild
+	// Cached compiles the script to the user's cache directory,
+	// like Run, and runs the binary, so scripts that have been
+	// run before start without compiling again.
+	Cached
+)
+
+// WithExecMode sets how the script is compiled and run.
+// WithCredential, WithBuildProgress, WithSandbox, WithEnv, WithEnvVar,
+// WithResourceLimits and WithModule need the script to be compiled
+// before it is run, so they make GoRun behave as GoBuild;
+// WithCredential does the same for Cached, since the cache usually
+// can't be read by other users.
+func WithExecMode(mode ExecMode) Option {
+	return func(o *options) {
+		o.mode = mode
 	}
-	argnames := make([]string, len(args))
-	for i := range args {
-		argnames[i] = args[i].Argname()
+}
+
+// execMode gets the ExecMode to use, taking account of the options
+// that need the script compiled first.
+func (o options) execMode() ExecMode {
+	switch {
+	case o.credential != nil:
+		return GoBuild
+	case o.mode == GoRun && (o.buildProgress != nil || o.env() != nil || o.module != nil || o.limits != nil):
+		return GoBuild
 	}
-	data := struct {
-		Goscript string
-		InArgs   []arg
-		ArgsList string
-	}{
-		Goscript: script,
-		InArgs:   args,
-		ArgsList: strings.Join(argnames, ", "),
+	return o.mode
+}
+
+// WithBuildProgress calls fn with each line of output from the go
+// tool while the script is compiled, so a UI can show progress for
+// scripts that take a while to build. The go tool lists each package
+// as it is built, followed by any errors.
+// Setting it makes New compile the script with go build before
+// running it, rather than using go run, whose output can't be told
+// apart from the script's (see WithExecMode).
+func WithBuildProgress(fn func(line string)) Option {
+	return func(o *options) {
+		o.buildProgress = fn
 	}
-	if err := scriptHarnessTemplate.Execute(f, data); err != nil {
-		return "", err
+}
+
+// WithRedactor sets a function that masks secrets in the output of
+// the compiler and the script before it is put in errors, or passed
+// to the OnCrash callback. See RedactPatterns for a simple redactor.
+func WithRedactor(fn func(string) string) Option {
+	return func(o *options) {
+		o.redact = fn
 	}
-	if err := f.Close(); err != nil {
-		return "", err
+}
+
+// redactor gets the redactor, which leaves output unchanged if none
+// is set.
+func (o options) redactor() func(string) string {
+	if o.redact == nil {
+		return func(s string) string { return s }
 	}
-	// to debug generated code, uncomment this block
-	// if err := scriptHarnessTemplate.Execute(os.Stdout, data); err != nil {
-	// 	return "", err
-	// }
-	return f.Name(), nil
+	return o.redact
 }
 
-func (s *Script) cmdErr(err error) error {
-	if s.cmd.ProcessState != nil && s.cmd.ProcessState.Exited() {
-		if !s.cmd.ProcessState.Success() {
-			stderrb, _ := ioutil.ReadAll(s.stderr)
-			return errors.New(string(stderrb))
+// RedactPatterns makes a redactor for WithRedactor, which replaces
+// text matching any of the patterns with [REDACTED]:
+//
+//	goscript.WithRedactor(goscript.RedactPatterns(
+//		regexp.MustCompile(`token=\S+`),
+//	))
+func RedactPatterns(patterns ...*regexp.Regexp) func(string) string {
+	return func(s string) string {
+		for _, re := range patterns {
+			s = re.ReplaceAllLiteralString(s, "[REDACTED]")
 		}
+		return s
 	}
-	return err
 }
 
-// Close shuts down the script and cleans up any used resources.
-func (s *Script) Close() error {
-	defer os.Remove(s.scriptFile)
-	if s.stdin != nil {
-		s.stdin.Close()
+// goCommand gets the go command to run and compile scripts with.
+func (o options) goCommand() string {
+	if o.goBinary == "" {
+		return "go"
+	}
+	return o.goBinary
+}
+
+// buildFlags gets the go build flags for the options.
+func (o options) buildFlags() []string {
+	var flags []string
+	if len(o.buildTags) > 0 {
+		flags = append(flags, "-tags", strings.Join(o.buildTags, ","))
+	}
+	if o.ldflags != "" {
+		flags = append(flags, "-ldflags", o.ldflags)
+	}
+	return append(flags, o.extraBuildFlags...)
+}
+
+// Script represents a script.
+type Script struct {
+	opts options
+	err  error
+
+	// mu guards proc and output. Calls hold it for reading, and it
+	// is held for writing while the process is swapped by Reload.
+	mu   sync.RWMutex
+	proc *process
+	// output is set on each process, to receive the script's
+	// output.
+	output io.Writer
+	// prog is the program compiled for proc, if the Script
+	// compiled it itself; it is removed along with the process.
+	prog *Program
+	// types holds the types registered with Register and
+	// RegisterTypeAs, which are registered with each process before
+	// it is used.
+	types []typeRegistration
+
+	latency latencyHistogram
+	// restarts counts the restarts by WithAutoRestart since a call
+	// last succeeded.
+	restarts int32
+	// closed is set by Close, which returns closeErr again if it is
+	// called again; both are guarded by mu.
+	closed   bool
+	closeErr error
+	// pending is set for scripts made by NewLazy until they are
+	// started, with script holding the script to start.
+	pending int32
+	script  string
+}
+
+// New makes a new running Script.
+// If the script can't be started, such as when it doesn't compile,
+// the error is returned by each call (see NewScript).
+// Caller must call Close.
+func New(script string, opts ...Option) *Script {
+	s := &Script{}
+	for _, opt := range opts {
+		opt(&s.opts)
+	}
+	s.proc, s.prog, s.err = start(script, s.opts)
+	return s
+}
+
+// NewLazy makes a new Script like New, but doesn't compile or start
+// it until Start is called, or the first call is made, so programs
+// that hold many scripts, only some of which are used, don't wait for
+// them all at startup:
+//
+//	script := goscript.NewLazy(src)
+//	defer script.Close()
+//	// later
+//	if err := script.Start(); err != nil {
+//		return err
+//	}
+//
+// If the script can't be started, the error is returned by Start, and
+// by each call.
+// Caller must call Close.
+func NewLazy(script string, opts ...Option) *Script {
+	s := &Script{pending: 1, script: script}
+	for _, opt := range opts {
+		opt(&s.opts)
 	}
-	if s.cmd != nil && s.cmd.Process != nil {
-		if s.cmd.ProcessState == nil || !s.cmd.ProcessState.Exited() {
-			s.cmd.Process.Kill()
+	return s
+}
+
+// Start starts a script made by NewLazy, and returns the error if it
+// can't be started, such as when it doesn't compile. Starting a
+// script again, or one made by New, does nothing but return the error
+// it failed to start with, if any.
+func (s *Script) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if atomic.LoadInt32(&s.pending) == 1 {
+		s.proc, s.prog, s.err = start(s.script, s.opts)
+		if s.proc != nil {
+			s.proc.setOutput(s.output)
 		}
+		s.script = ""
+		atomic.StoreInt32(&s.pending, 0)
+	}
+	return s.err
+}
+
+// ensureStarted starts the script if it was made by NewLazy and
+// hasn't been started yet. Errors are left for the call to return.
+func (s *Script) ensureStarted() {
+	if atomic.LoadInt32(&s.pending) == 1 {
+		s.Start()
+	}
+}
+
+// NewScript is like New, but returns the error if the script can't be
+// started, rather than a Script whose calls all fail.
+// Caller must call Close if the error is nil.
+func NewScript(script string, opts ...Option) (*Script, error) {
+	s := New(script, opts...)
+	if s.err != nil {
+		return nil, s.err
 	}
-	if s.stdout != nil {
-		s.stdout.Close()
+	return s, nil
+}
+
+// NewReader is like NewScript, but reads the script from r.
+func NewReader(r io.Reader, opts ...Option) (*Script, error) {
+	script, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("goscript: reading script: %w", err)
 	}
-	if s.stderr != nil {
-		s.stderr.Close()
+	return NewScript(string(script), opts...)
+}
+
+// NewFile is like NewScript, but reads the script from the file at
+// path, such as a .goscript file kept alongside the program.
+func NewFile(path string, opts ...Option) (*Script, error) {
+	script, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("goscript: reading script: %w", err)
 	}
-	return nil
+	return NewScript(string(script), opts...)
 }
 
-func processOutput(scriptLines int, out []byte) string {
-	var lines []string
-	s := bufio.NewScanner(bytes.NewReader(out))
-	for s.Scan() {
-		line := s.Text()
-		trimline := strings.TrimSpace(line)
-		if trimline == "# command-line-arguments" {
-			continue
+// start starts a process running the script, as set by the ExecMode.
+// If the script is compiled to a temporary directory, the Program is
+// returned too, for closing once the process has been closed.
+func start(script string, opts options) (*process, *Program, error) {
+	switch opts.execMode() {
+	case Cached:
+		p, err := startCached(script, opts)
+		return p, nil, err
+	case GoBuild:
+		prog, err := compile(script, opts)
+		if err != nil {
+			return nil, nil, err
 		}
-		if strings.Contains(line, "goscript.go:") {
-			// error lines should be tweaked
-			segs := strings.Split(line, ":")
-			segs[0] = "goscript"
-			n, err := strconv.Atoi(segs[1])
-			if err == nil {
-				scriptlineN := n - scriptStartLine
-				if scriptlineN > scriptLines {
-					// skip errors on lines outside of the users
-					// script file.
-					continue
-				}
-				segs[1] = strconv.Itoa(n - scriptStartLine)
-			}
-			line = strings.Join(segs, ":")
+		p, err := startProgramProcess(prog)
+		if err != nil {
+			prog.Close()
+			return nil, nil, err
 		}
-		lines = append(lines, line)
+		return p, prog, nil
+	default:
+		p, err := startProcess(script, opts)
+		return p, nil, err
 	}
-	return strings.Join(lines, "\n")
 }
 
-type response struct {
-	Value interface{}
-	Error error
+// Execute executes the script with the specified arguments, and
+// returns the response.
+func (s *Script) Execute(args ...interface{}) (interface{}, error) {
+	return s.ExecuteContext(context.Background(), args...)
 }
 
-var scriptHarnessTemplate *template.Template
-var scriptHarnessTemplateErr error
-var scriptStartLine int
+// ExecuteContext is like Execute, but takes a context carrying
+// request-scoped values such as a request ID (see ContextWithRequestID).
+// If the context is already done, its error is returned without
+// calling the script.
+//
+// If the context is done while the script is running, the script
+// process is killed, and the context's error is returned; scripts
+// that take the deadline (see below) get a moment to return once it
+// has passed first. The process is restarted by the next call.
+//
+// The context's deadline is passed on to scripts whose first
+// parameter is a context.Context, or is named deadline and is a
+// time.Time, so they can limit their work to fit. These parameters
+// are provided by goscript rather than passed to ExecuteContext:
+//
+//	func goscript(ctx context.Context, urls []string) (int, error)
+//	func goscript(deadline time.Time, urls []string) (int, error)
+//
+// With no deadline, the context has none, and deadline is the zero
+// time.
+func (s *Script) ExecuteContext(ctx context.Context, args ...interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	req := request{Args: args, RequestID: RequestIDFromContext(ctx), Deadline: deadline}
+	res, err := s.execute(ctx, req, IdempotentFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return res.Value, res.Error
+}
 
-func init() {
-	scriptHarnessTemplate, scriptHarnessTemplateErr = template.New("goscript").Parse(scriptHarnessCode)
-	s := bufio.NewScanner(strings.NewReader(scriptHarnessCode))
-	line := 1
-	for s.Scan() {
-		if s.Text() == "{{ .Goscript }}" {
-			break
+// execute sends the request to the current process.
+// Script panics are returned as a PanicError.
+// Idempotent calls are retried if the process exits, as set by
+// WithExecuteRetries, and calls to crashed processes are retried once
+// WithAutoRestart, unless ctx is done.
+func (s *Script) execute(ctx context.Context, req request, idempotent bool) (response, error) {
+	s.ensureStarted()
+	autoRestarted := false
+	for attempt := 0; ; attempt++ {
+		res, p, err := s.executeOnce(ctx, req)
+		if err == nil && s.opts.autoRestart {
+			atomic.StoreInt32(&s.restarts, 0)
 		}
-		line++
+		if err != nil && s.opts.autoRestart && !autoRestarted && p != nil && p.poisoned() && ctx.Err() == nil {
+			autoRestarted = true
+			if err := s.autoRestart(p); err != nil {
+				return res, err
+			}
+			continue
+		}
+		if err == nil || !idempotent || attempt >= s.opts.executeRetries || p == nil || !p.exited() || ctx.Err() != nil {
+			return res, err
+		}
+		s.restart(p)
+		time.Sleep(retryBackoff(attempt))
 	}
-	scriptStartLine = line - 1
 }
 
-var scriptHarnessCode = `// Code generated by goscript; DO NOT EDIT
-// github.com/matryer/goscript
+// autoRestart restarts p for WithAutoRestart, after a backoff that
+// grows with each restart since a call last succeeded, or returns
+// ErrTooManyRestarts if there have been too many.
+func (s *Script) autoRestart(p *process) error {
+	n := atomic.AddInt32(&s.restarts, 1)
+	if n > maxAutoRestarts {
+		return ErrTooManyRestarts
+	}
+	time.Sleep(retryBackoff(int(n - 1)))
+	s.restart(p)
+	return nil
+}
 
-package main
+// executeOnce sends the request to the current process, which is
+// returned too. If the process was killed by a cancelled call, it is
+// restarted first.
+func (s *Script) executeOnce(ctx context.Context, req request) (response, *process, error) {
+	s.mu.RLock()
+	p := s.proc
+	s.mu.RUnlock()
+	if p != nil && p.cancelled() {
+		s.restart(p)
+	}
+	s.mu.RLock()
+	if s.err != nil {
+		s.mu.RUnlock()
+		return response{}, nil, s.err
+	}
+	p = s.proc
+	start := time.Now()
+	res, err := p.execute(ctx, req, s.types)
+	elapsed := time.Since(start)
+	s.latency.observe(elapsed)
+	s.mu.RUnlock()
+	if s.opts.observer != nil {
+		s.opts.observer.OnExecute(elapsed, callErr(res, err))
+	}
+	if err != nil {
+		return res, p, err
+	}
+	if s.opts.maxExecutions > 0 && atomic.AddInt64(&p.executions, 1) >= int64(s.opts.maxExecutions) {
+		s.restart(p)
+	}
+	if res.Panicked {
+		return res, p, PanicError{Value: res.Panic, Stack: res.Stack}
+	}
+	return res, p, nil
+}
 
-import (
-	"encoding/gob"
-	"os"
-	"log"
-)
+// retryBackoff is how long to wait before retrying a call, which
+// doubles with each attempt, with jitter so that calls retried
+// together spread out.
+func retryBackoff(attempt int) time.Duration {
+	d := 50 * time.Millisecond << uint(attempt)
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
 
-// <goscript>
-{{ .Goscript }}
-// </goscript>
+// restart replaces p with a fresh process running the same script.
+// If p has already been replaced, or is being restarted by another
+// call, restart does nothing. If the new process fails to start, p
+// is kept, and restarting is tried again after the next call.
+func (s *Script) restart(p *process) {
+	if !atomic.CompareAndSwapInt32(&p.restarting, 0, 1) {
+		return
+	}
+	newp, err := s.respawn(p)
+	if err != nil {
+		atomic.StoreInt32(&p.restarting, 0)
+		return
+	}
+	s.mu.Lock()
+	if s.proc != p {
+		s.mu.Unlock()
+		newp.close()
+		return
+	}
+	s.proc = newp
+	newp.setOutput(s.output)
+	s.mu.Unlock()
+	p.close()
+}
 
-func main() {
-	r := gob.NewDecoder(os.Stdin)
-	w := gob.NewEncoder(os.Stdout)
-	if err := w.Encode("ready"); err != nil {
-		log.Fatalln(err)
+// respawn starts a fresh process running the same program as p, or
+// the same script if p was run with go run.
+func (s *Script) respawn(p *process) (*process, error) {
+	if p.program != nil {
+		return startProgramProcess(p.program)
 	}
-	for {
-		var args []interface{}
-		if err := r.Decode(&args); err != nil {
-			log.Fatalln(err)
-		}
-		{{- range .InArgs }}
-		{{- if .Variadic }}
-		{{ .Name }} := make({{ .Typename }}, len(args)-{{ .Index }})
-		for i := {{ .Index }}; i < len(args); i++ {
-			{{ .Name }}[i-{{ .Index }}] = args[i].({{ .TypenameSingular }})
-		}
-		{{- else }}
-		{{ .Name }} := args[{{ .Index }}].({{ .Typename }})
-		{{- end }}
-		{{- end }}
-		var res response
-		res.Value, res.Error = goscript({{ .ArgsList }})
-		if err := w.Encode(res); err != nil {
-			log.Fatalln(err)
-		}
+	return startProcess(p.script, s.opts)
+}
+
+// Reset replaces the script process with a fresh one, so the script
+// starts again with its package-level variables as they were first
+// initialized. Unlike creating the script again, it doesn't compile
+// the script, unless it is run with go run (see WithExecMode).
+// Calls in flight finish first. Reset also recovers a script that
+// calls can't be made to (see ErrPoisoned).
+func (s *Script) Reset() error {
+	s.ensureStarted()
+	s.mu.RLock()
+	p, err := s.proc, s.err
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	newp, err := s.respawn(p)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.proc != p {
+		// replaced by another call in the meantime
+		s.mu.Unlock()
+		newp.close()
+		return nil
 	}
+	s.proc = newp
+	newp.setOutput(s.output)
+	atomic.StoreInt32(&s.restarts, 0)
+	s.mu.Unlock()
+	// the old process may have crashed, which Reset recovers from
+	p.close()
+	return nil
 }
 
-type response struct {
-	Value interface{}
-	Error error
+// Healthy reports whether the script can take calls. It is false if
+// the script failed to start, or hasn't been started yet (see
+// NewLazy), or an earlier call left it unusable, in which case calls
+// return ErrPoisoned until it is reloaded.
+func (s *Script) Healthy() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.err == nil && s.proc != nil && !s.proc.poisoned()
+}
+
+// pingTimeout is how long Ping waits for the script to answer.
+const pingTimeout = 5 * time.Second
+
+// Ping checks the script process is running and answering, without
+// calling the goscript function, which is useful before sending work
+// to a script that has been idle. If a call is running, Ping waits for
+// it to finish.
+// If the process has exited, such as when it was killed for using too
+// much memory, ErrPoisoned is returned. If it doesn't answer within a
+// few seconds, it is killed, and restarted by the next call.
+func (s *Script) Ping() error {
+	s.ensureStarted()
+	s.mu.RLock()
+	p := s.proc
+	s.mu.RUnlock()
+	if p != nil && p.cancelled() {
+		s.restart(p)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.err != nil {
+		return s.err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	return s.proc.ping(ctx)
+}
+
+// restartIfExited restarts the script's process if it has exited,
+// and reports whether it had.
+func (s *Script) restartIfExited() bool {
+	s.mu.RLock()
+	p := s.proc
+	s.mu.RUnlock()
+	if p == nil || !p.exited() {
+		return false
+	}
+	s.restart(p)
+	return true
+}
+
+type contextKey string
+
+// requestIDKey is the context key for the request ID.
+var requestIDKey = contextKey("goscript request id")
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID.
+// Calls made with ExecuteContext pass the ID to the script, where it
+// is available from the RequestID function, so script logs can be
+// correlated with the caller's:
+//
+//	func goscript(name string) (string, error) {
+//		log.Println(RequestID(), "greeting", name)
+//		return "Hello " + name, nil
+//	}
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestIDFromContext gets the request ID from ctx, or an empty string
+// if there isn't one.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// idempotentKey is the context key for marking calls idempotent.
+var idempotentKey = contextKey("goscript idempotent")
+
+// ContextWithIdempotent returns a copy of ctx that marks calls made
+// with ExecuteContext as idempotent, meaning they are safe to make
+// again if the script process exits during the call (see
+// WithExecuteRetries).
+func ContextWithIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey, true)
+}
+
+// IdempotentFromContext gets whether ctx marks calls as idempotent.
+func IdempotentFromContext(ctx context.Context) bool {
+	idempotent, _ := ctx.Value(idempotentKey).(bool)
+	return idempotent
+}
+
+// ExecuteTo executes the script with the specified arguments, and
+// writes the encoded response value to w instead of decoding it.
+// This allows results to be proxied without decoding and re-encoding
+// them. The bytes written are a self-contained gob stream, which can
+// be decoded with DecodeResult, or with JSONCodec, the JSON encoding
+// of the value.
+// Nothing is written if the script returns an error.
+func (s *Script) ExecuteTo(w io.Writer, args ...interface{}) error {
+	res, err := s.execute(context.Background(), request{Args: args, Raw: true}, false)
+	if err != nil {
+		return err
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	_, err = w.Write(res.Raw)
+	return err
+}
+
+// ExecuteInto executes the script with the specified arguments, and
+// decodes the response value into dst, which must be a non-nil
+// pointer. Decoding into the known type avoids decoding into an
+// interface{} and asserting its type, and means the type of the value
+// doesn't need registering.
+// If the script returns nil, dst is left unchanged.
+func (s *Script) ExecuteInto(dst interface{}, args ...interface{}) error {
+	if v := reflect.ValueOf(dst); v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.New("goscript: ExecuteInto needs a non-nil pointer")
+	}
+	res, err := s.execute(context.Background(), request{Args: args, Typed: true}, false)
+	if err != nil {
+		return err
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	return s.decodeTyped(res.Raw, dst)
+}
+
+// decodeTyped decodes a value encoded for a typed request into dst.
+func (s *Script) decodeTyped(raw []byte, dst interface{}) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	if s.opts.codec == JSONCodec {
+		return json.Unmarshal(raw, dst)
+	}
+	return gob.NewDecoder(bytes.NewReader(raw)).Decode(dst)
+}
+
+// ExecuteTypedSlice executes the script with the specified arguments,
+// and decodes the response value into a []T, which saves decoding
+// into a slice of interface{} and asserting the type of each element.
+// As with ExecuteInto, T doesn't need registering.
+// An error is returned if the script returns something other than a
+// slice.
+func ExecuteTypedSlice[T any](s *Script, args ...interface{}) ([]T, error) {
+	res, err := s.execute(context.Background(), request{Args: args, Typed: true}, false)
+	if err != nil {
+		return nil, err
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	var dst []T
+	if err := s.decodeTyped(res.Raw, &dst); err != nil {
+		return nil, fmt.Errorf("goscript: result isn't a %T: %w", dst, err)
+	}
+	return dst, nil
+}
+
+// DecodeResult decodes a value written by ExecuteTo.
+// Any types other than the basic types must be registered with
+// gob.Register.
+func DecodeResult(r io.Reader) (interface{}, error) {
+	var v rawValue
+	if err := gob.NewDecoder(r).Decode(&v); err != nil {
+		return nil, err
+	}
+	return v.Value, nil
+}
+
+// rawValue wraps values encoded for ExecuteTo, so they are encoded
+// with their type.
+type rawValue struct {
+	Value interface{}
+}
+
+// Progress gets the latest progress reported by the running call,
+// or the last call if none is running.
+// Scripts report progress by taking a func(int) parameter, which is
+// provided by goscript rather than passed to Execute:
+//
+//	func goscript(setProgress func(int), items []string) (int, error)
+//
+// Progress is reset to zero at the start of each call.
+func (s *Script) Progress() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.proc == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&s.proc.progress))
+}
+
+// SetOutput sets the writer that receives what the script writes to
+// os.Stdout, such as with fmt.Println, which is otherwise discarded.
+// Output is sent separately from the script's responses, so it can't
+// corrupt them, and is written to w as it arrives, which may be just
+// after the call that wrote it has returned.
+// Writes to os.Stderr are still collected for the Stderr of Errors,
+// and for Stderr.
+func (s *Script) SetOutput(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.output = w
+	if s.proc != nil {
+		s.proc.setOutput(w)
+	}
+}
+
+// Stderr gets what the script process has written to stderr, such as
+// warnings logged by calls that go on to succeed, which would
+// otherwise only be seen in the Stderr of an Error.
+// Stderr is collected as it is written, so it may arrive just after
+// the call that wrote it has returned, and only the last megabyte is
+// kept. It starts afresh when the process is restarted.
+func (s *Script) Stderr() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.proc == nil {
+		return ""
+	}
+	return s.proc.redact(processOutput(s.proc.stderrBuf.Bytes()))
+}
+
+// Register registers the types of values with gob, under their
+// default names (see gob.Register), both in the calling program and in
+// the script, so that values of the types can be passed to and
+// returned from the script.
+// Each value must be a named struct, or a pointer to one, and the
+// script must declare a struct type with the same name.
+// Basic types, and common maps and slices of them such as
+// map[string]int, map[string]interface{} and [][]string, are
+// registered already.
+func (s *Script) Register(values ...interface{}) error {
+	var regs []typeRegistration
+	for _, v := range values {
+		reg, ok, err := registerType(v)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("goscript: register: %T is not a named struct type", v)
+		}
+		regs = append(regs, reg)
+	}
+	s.mu.Lock()
+	s.types = append(s.types, regs...)
+	s.mu.Unlock()
+	return nil
+}
+
+// RegisterTypeAs registers the type of v with gob under the given name,
+// both in the calling program and in the script, so that values of the
+// type can be passed to and returned from the script.
+// The script must declare a struct type with the same name as the
+// type of v (or of the type v points to).
+//
+// Gob keys types by name, and the default names (see gob.Register)
+// can collide when different types with the same name are used in
+// one process, for example two Request types from different packages.
+// Explicit names avoid this.
+func (s *Script) RegisterTypeAs(name string, v interface{}) error {
+	reg, err := registerTypeAs(name, v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.types = append(s.types, reg)
+	s.mu.Unlock()
+	return nil
+}
+
+// Reload replaces the running script with a new one.
+// Calls already in flight complete against the old script, and
+// later calls are handled by the new one.
+// If the new script fails to start, the old one is left running
+// and the error is returned.
+func (s *Script) Reload(script string) error {
+	p, prog, err := start(script, s.opts)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	old, oldProg := s.proc, s.prog
+	s.proc, s.prog = p, prog
+	p.setOutput(s.output)
+	s.err = nil
+	// a script made by NewLazy is started by reloading it
+	s.script = ""
+	atomic.StoreInt32(&s.pending, 0)
+	atomic.StoreInt32(&s.restarts, 0)
+	s.mu.Unlock()
+	if old != nil {
+		old.close()
+	}
+	if oldProg != nil {
+		oldProg.Close()
+	}
+	return nil
+}
+
+// processScript checks the script, and gets the parameters of the
+// function named entry, with the types in typeArgs given to its type
+// parameters.
+func processScript(script, entry string, typeArgs map[string]string) ([]arg, error) {
+	if err := checkEntryName(entry); err != nil {
+		return nil, err
+	}
+	if err := checkWrapper(script, entry); err != nil {
+		return nil, err
+	}
+	funcs, err := parseScript(script, entry)
+	switch {
+	case err != nil:
+		// let the compiler report it, unless it can be described
+		// better here
+		return nil, mixedParamsError(err)
+	case funcs.goscript != nil:
+		if _, err := funcs.goscript.instantiate(entry, typeArgs); err != nil {
+			return nil, err
+		}
+		return funcs.goscript.params, nil
+	}
+	entries, err := entryPoints(script, entry)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		// the script is only called with Call
+		return nil, nil
+	}
+	return nil, fmt.Errorf("missing func %s", entry)
+}
+
+// checkWrapper makes sure the script doesn't declare things the
+// harness provides, since it is wrapped in package main alongside
+// the harness's own func main, and declarations named goscript
+// followed by anything other than an underscore, which are reserved
+// for the harness. The function named entry is allowed.
+func checkWrapper(script, entry string) error {
+	var sc scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("goscript.go", -1, len(script))
+	sc.Init(file, []byte(script), nil, 0)
+	for {
+		_, tok, _ := sc.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.PACKAGE {
+			return errors.New("goscript: scripts must not have a package clause; goscript places the script in package main")
+		}
+	}
+	f, err := parser.ParseFile(fset, "goscript.go", "package main\n"+script, 0)
+	if err != nil {
+		// let the compiler report it
+		return nil
+	}
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if ok && fn.Recv == nil && fn.Name.Name == "main" {
+			return errors.New("goscript: scripts must not declare func main; goscript provides its own main that calls func goscript")
+		}
+	}
+	for _, ident := range declaredNames(f) {
+		name := ident.Name
+		if name == "main" || name != entry && strings.HasPrefix(name, "goscript") && !strings.HasPrefix(name, "goscript_") && name != "goscript" {
+			// the line is reported as it is in the script, which
+			// has no package clause
+			return fmt.Errorf("goscript:%d: %q is reserved by goscript", fset.Position(ident.Pos()).Line-1, name)
+		}
+	}
+	for _, ident := range entryParamNames(f, entry) {
+		// the harness declares the parameters alongside its own
+		// locals, and calls the entry points by name
+		if strings.HasPrefix(ident.Name, "goscript") || ident.Name == entry {
+			return fmt.Errorf("goscript:%d: %q is reserved by goscript", fset.Position(ident.Pos()).Line-1, ident.Name)
+		}
+	}
+	return nil
+}
+
+// entryParamNames gets the names of the parameters of the function
+// named entry, and of the other entry points, which the harness
+// declares as it calls them.
+func entryParamNames(f *ast.File, entry string) []*ast.Ident {
+	var names []*ast.Ident
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || fn.Name.Name != entry && !strings.HasPrefix(fn.Name.Name, "goscript_") {
+			continue
+		}
+		for _, field := range fn.Type.Params.List {
+			names = append(names, field.Names...)
+		}
+	}
+	return names
+}
+
+// declaredNames gets the identifiers declared at the top level of the
+// file, other than methods.
+func declaredNames(f *ast.File) []*ast.Ident {
+	var names []*ast.Ident
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				names = append(names, decl.Name)
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					names = append(names, spec.Name)
+				case *ast.ValueSpec:
+					names = append(names, spec.Names...)
+				case *ast.ImportSpec:
+					if spec.Name != nil {
+						names = append(names, spec.Name)
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+type arg struct {
+	Index int
+	Name  string
+	Typ   string
+	// ArgIndex is the position of the argument in the values
+	// passed to Execute.
+	ArgIndex int
+}
+
+// Progress gets whether the argument is a progress function, which
+// is provided by the harness rather than passed to Execute.
+func (a arg) Progress() bool {
+	return a.Typ == "func(int)"
+}
+
+// Yield gets whether the argument is a function the script passes
+// values to for ExecuteStream, which is provided by the harness.
+func (a arg) Yield() bool {
+	return a.Typ == "func(interface{})" || a.Typ == "func(any)"
+}
+
+// Deadline gets whether the argument is a first parameter named
+// deadline of type time.Time, which is provided by the harness.
+func (a arg) Deadline() bool {
+	return a.Index == 0 && a.Name == "deadline" && a.Typ == "time.Time"
+}
+
+// Context gets whether the argument is a first parameter of type
+// context.Context, which is provided by the harness.
+func (a arg) Context() bool {
+	return a.Index == 0 && a.Typ == "context.Context"
+}
+
+// Provided gets whether the argument is provided by the harness
+// rather than passed to Execute.
+func (a arg) Provided() bool {
+	return a.Progress() || a.Yield() || a.Deadline() || a.Context()
+}
+
+func (a arg) Variadic() bool {
+	return strings.HasPrefix(a.Typ, "...")
+}
+
+func (a arg) Argname() string {
+	if a.Variadic() {
+		return a.Name + "..."
+	}
+	return a.Name
+}
+
+func (a arg) Typename() string {
+	if a.Variadic() {
+		return "[]" + a.Typ[3:]
+	}
+	return a.Typ
+}
+
+func (a arg) TypenameSingular() string {
+	if a.Variadic() {
+		return a.Typ[3:]
+	}
+	return a.Typ
+}
+
+// coerceArgs parses any string args into the types of the
+// corresponding parameters.
+func coerceArgs(params []arg, args []interface{}) ([]interface{}, error) {
+	coerced := make([]interface{}, len(args))
+	copy(coerced, args)
+	for _, param := range params {
+		if param.Provided() {
+			continue
+		}
+		end := param.ArgIndex + 1
+		if param.Variadic() {
+			end = len(args)
+		}
+		for i := param.ArgIndex; i < end && i < len(args); i++ {
+			s, ok := args[i].(string)
+			if !ok {
+				continue
+			}
+			v, err := parseArg(s, param.TypenameSingular())
+			if err != nil {
+				return nil, fmt.Errorf("goscript: argument %s: %s", param.Name, err)
+			}
+			coerced[i] = v
+		}
+	}
+	return coerced, nil
+}
+
+// checkArgCount checks the number of args matches the params, so
+// that the script isn't sent a call it can't make.
+func checkArgCount(params []arg, args []interface{}) error {
+	n := 0
+	variadic := false
+	var types []string
+	for _, param := range params {
+		if param.Provided() {
+			continue
+		}
+		types = append(types, param.Typ)
+		if param.Variadic() {
+			variadic = true
+			continue
+		}
+		n++
+	}
+	if len(args) == n || (variadic && len(args) > n) {
+		return nil
+	}
+	want := strconv.Itoa(n)
+	if variadic {
+		want = "at least " + want
+	}
+	return fmt.Errorf("goscript: got %d arguments, want %s (%s)", len(args), want, strings.Join(types, ", "))
+}
+
+// checkArgs checks the types of args match the params exactly.
+func checkArgs(params []arg, args []interface{}) error {
+	for _, param := range params {
+		if param.Provided() {
+			continue
+		}
+		end := param.ArgIndex + 1
+		if param.Variadic() {
+			end = len(args)
+		}
+		for i := param.ArgIndex; i < end && i < len(args); i++ {
+			if !argTypeMatches(reflect.TypeOf(args[i]), param.TypenameSingular()) {
+				got := "nil"
+				if args[i] != nil {
+					got = reflect.TypeOf(args[i]).String()
+				}
+				return fmt.Errorf("goscript: argument %s: got %s, want %s", param.Name, got, param.TypenameSingular())
+			}
+		}
+	}
+	return nil
+}
+
+// argTypeMatches reports whether t is the parameter type typ.
+// Named types from other packages match by name alone, since that is
+// how the caller's types are matched to types declared in the script.
+func argTypeMatches(t reflect.Type, typ string) bool {
+	if typ == "interface{}" || typ == "any" {
+		return true
+	}
+	if t == nil {
+		return false
+	}
+	if t.String() == typ {
+		return true
+	}
+	switch {
+	case strings.HasPrefix(typ, "*") && t.Kind() == reflect.Ptr:
+		return argTypeMatches(t.Elem(), typ[1:])
+	case strings.HasPrefix(typ, "[]") && t.Kind() == reflect.Slice:
+		return argTypeMatches(t.Elem(), typ[2:])
+	}
+	return t.PkgPath() != "" && t.Name() == typ
+}
+
+// parseArg parses s into a value of the named basic type.
+// Strings for other types are returned unchanged.
+func parseArg(s, typ string) (interface{}, error) {
+	switch typ {
+	case "bool":
+		return strconv.ParseBool(s)
+	case "int", "int8", "int16", "int32", "int64":
+		bits, _ := strconv.Atoi(strings.TrimPrefix(typ, "int"))
+		n, err := strconv.ParseInt(s, 10, bits)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.ValueOf(n).Convert(basicTypes[typ]).Interface(), nil
+	case "uint", "uint8", "uint16", "uint32", "uint64":
+		bits, _ := strconv.Atoi(strings.TrimPrefix(typ, "uint"))
+		n, err := strconv.ParseUint(s, 10, bits)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.ValueOf(n).Convert(basicTypes[typ]).Interface(), nil
+	case "float32", "float64":
+		bits, _ := strconv.Atoi(strings.TrimPrefix(typ, "float"))
+		n, err := strconv.ParseFloat(s, bits)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.ValueOf(n).Convert(basicTypes[typ]).Interface(), nil
+	}
+	return s, nil
+}
+
+// basicTypes maps the names of the numeric types to their types.
+var basicTypes = map[string]reflect.Type{
+	"int":     reflect.TypeOf(int(0)),
+	"int8":    reflect.TypeOf(int8(0)),
+	"int16":   reflect.TypeOf(int16(0)),
+	"int32":   reflect.TypeOf(int32(0)),
+	"int64":   reflect.TypeOf(int64(0)),
+	"uint":    reflect.TypeOf(uint(0)),
+	"uint8":   reflect.TypeOf(uint8(0)),
+	"uint16":  reflect.TypeOf(uint16(0)),
+	"uint32":  reflect.TypeOf(uint32(0)),
+	"uint64":  reflect.TypeOf(uint64(0)),
+	"float32": reflect.TypeOf(float32(0)),
+	"float64": reflect.TypeOf(float64(0)),
+}
+
+// usesRequestID gets whether the script refers to a RequestID it
+// doesn't declare itself, so that mentions in comments or strings,
+// and methods of that name, don't count.
+func usesRequestID(script string) bool {
+	f, err := parser.ParseFile(token.NewFileSet(), "goscript.go", "package main\n"+script, 0)
+	if err != nil {
+		return false
+	}
+	for _, ident := range f.Unresolved {
+		if ident.Name == "RequestID" {
+			return true
+		}
+	}
+	return false
+}
+
+// structTypes gets the names of the non-generic struct types
+// declared at the top level of the script.
+// Scripts that don't parse yield no types, leaving the compiler
+// to report the problem.
+func structTypes(script string) []string {
+	f, err := parser.ParseFile(token.NewFileSet(), "goscript.go", "package main\n"+script, 0)
+	if err != nil {
+		return nil
+	}
+	var types []string
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if ts.TypeParams != nil {
+				continue
+			}
+			if _, ok := ts.Type.(*ast.StructType); ok {
+				types = append(types, ts.Name.Name)
+			}
+		}
+	}
+	return types
+}
+
+// writeScriptFile writes the generated source, any extra source
+// files, and the go.mod file if the script is in a module, to a new
+// temporary directory, and returns the directory, which the caller
+// must remove, and the paths of the source files.
+func writeScriptFile(src []byte, opts options) (string, []string, error) {
+	if err := checkSourceFiles(opts.sourceFiles); err != nil {
+		return "", nil, err
+	}
+	if err := checkBuildTags(opts.buildTags); err != nil {
+		return "", nil, err
+	}
+	if err := checkBuildFlags(opts.extraBuildFlags); err != nil {
+		return "", nil, err
+	}
+	dir, err := ioutil.TempDir("", "goscript")
+	if err != nil {
+		return "", nil, err
+	}
+	files := []string{filepath.Join(dir, "goscript.go")}
+	if err := ioutil.WriteFile(files[0], src, 0600); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+	if opts.module != nil {
+		goMod := opts.module.goMod(goVersion(opts.goCommand()))
+		if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), goMod, 0600); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+	}
+	// the go command ignores the build constraints of the files it is
+	// given, so only those that match are given
+	ctx := gobuild.Default
+	ctx.BuildTags = opts.buildTags
+	for _, sf := range opts.sourceFiles {
+		name := filepath.Join(dir, sf.name)
+		if err := ioutil.WriteFile(name, []byte(sf.src), 0600); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+		match, err := ctx.MatchFile(dir, sf.name)
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+		if match {
+			files = append(files, name)
+		}
+	}
+	// package-level variables are initialized in the order of the
+	// files given to the compiler, which is by name when the module's
+	// package is built
+	first := files[0]
+	if opts.module != nil {
+		for _, name := range files[1:] {
+			if name < first {
+				first = name
+			}
+		}
+	}
+	firstSrc, err := ioutil.ReadFile(first)
+	if err == nil {
+		err = ioutil.WriteFile(first, insertStart(firstSrc), 0600)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+	return dir, files, nil
+}
+
+// buildTagRegexp matches valid build tags.
+var buildTagRegexp = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+
+// checkBuildTags checks the build tags can be passed to the go command.
+func checkBuildTags(tags []string) error {
+	for _, tag := range tags {
+		if !buildTagRegexp.MatchString(tag) {
+			return fmt.Errorf("goscript: invalid build tag %q", tag)
+		}
+	}
+	return nil
+}
+
+// sourceFileNameRegexp matches valid names for extra source files.
+var sourceFileNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*\.go$`)
+
+// checkSourceFiles checks the extra source files can be compiled
+// alongside the script.
+func checkSourceFiles(files []sourceFile) error {
+	seen := map[string]bool{"goscript.go": true}
+	for _, sf := range files {
+		if !sourceFileNameRegexp.MatchString(sf.name) || strings.HasSuffix(sf.name, "_test.go") {
+			return fmt.Errorf("goscript: invalid source file name %q", sf.name)
+		}
+		if seen[sf.name] {
+			return fmt.Errorf("goscript: duplicate source file %q", sf.name)
+		}
+		seen[sf.name] = true
+		f, err := parser.ParseFile(token.NewFileSet(), sf.name, sf.src, parser.PackageClauseOnly)
+		if err != nil {
+			return fmt.Errorf("goscript: source file %s: %s", sf.name, err)
+		}
+		if f.Name.Name != "main" {
+			return fmt.Errorf("goscript: source file %s must be package main", sf.name)
+		}
+	}
+	return nil
+}
+
+// generateScript writes the program that runs the script to w.
+func generateScript(w io.Writer, script string, args []arg, opts options) error {
+	if opts.restrictImports {
+		if err := checkAllowedImports(script, opts); err != nil {
+			return err
+		}
+	}
+	var usesExec bool
+	if opts.restrictCommands {
+		var err error
+		if script, usesExec, err = restrictCommands(script); err != nil {
+			return err
+		}
+	}
+	pipe := isPipeScript(script, opts.entryName())
+	if pipe {
+		// the filter's reader and writer aren't arguments
+		args = nil
+	}
+	argnames := make([]string, len(args))
+	for i := range args {
+		argnames[i] = args[i].Argname()
+	}
+	entries, err := entryPoints(script, opts.entryName())
+	if err != nil {
+		return err
+	}
+	data := struct {
+		Goscript string
+		// HarnessLine is the line of the generated file
+		// following the script.
+		HarnessLine    int
+		StartingMarker string
+		ReadyMarker    string
+		// Imports holds extra import specs needed by the harness,
+		// aliased so they don't collide with the script's imports.
+		Imports      []string
+		InArgs       []arg
+		ArgsUsed     bool
+		ArgsList     string
+		Progress     bool
+		Yield        bool
+		Context      bool
+		RequestID    bool
+		StdoutResult bool
+		JSON         bool
+		Types        []string
+		Seccomp      *seccompProgram
+		// Pipe is set if func goscript is a filter, which is
+		// only called by Pipe, with PipeArg as the program's
+		// argument.
+		Pipe    bool
+		PipeArg string
+		Limits  *ResourceLimits
+		// RequestsFD is the file descriptor requests are read
+		// from, if the script has its own stdin (see WithStdin).
+		RequestsFD int
+		// ResponsesFD is the file descriptor responses are written
+		// to, and StdoutFD the read end of stdout, if stdout is a
+		// pipe read back for WithStdoutResult.
+		ResponsesFD int
+		StdoutFD    int
+		// RestrictCommands is set if the script uses os/exec and
+		// may only run AllowedCommands.
+		RestrictCommands bool
+		AllowedCommands  []string
+		LeakCheck        bool
+		Gzip             bool
+		// Entries holds the entry points other than func goscript,
+		// and NoGoscript is set if there is no func goscript.
+		Entries    []entryPoint
+		NoGoscript bool
+		// Results lists names for the values returned by func
+		// goscript before its error, if there are several, and
+		// ErrorOnly is set if it returns only an error.
+		Results     string
+		ErrorOnly   bool
+		CommonTypes []string
+		// Entry is the name of the function Execute calls, and
+		// TypeArgs instantiates it if it is generic.
+		Entry    string
+		TypeArgs string
+		// NamedArg is the name of the struct parameter whose fields
+		// ExecuteNamed sets, if func goscript takes one.
+		NamedArg string
+		// StreamWindow is how many chunks or yielded values the
+		// script sends before waiting for the caller's Ack.
+		StreamWindow int
+	}{
+		Goscript:       script,
+		HarnessLine:    scriptStartLine + strings.Count(script, "\n") + 3,
+		StartingMarker: startingMarker,
+		StreamWindow:   streamWindow,
+		ReadyMarker:    opts.compression.readyMarker(),
+		InArgs:         args,
+		ArgsList:       strings.Join(argnames, ", "),
+		StdoutResult:   opts.stdoutResult,
+		JSON:           opts.codec == JSONCodec,
+		LeakCheck:      opts.onLeak != nil,
+		Gzip:           opts.compression == CompressGzip,
+		Entries:        entries,
+		NoGoscript:     !declaresGoscript(script, opts.entryName()),
+		Results:        resultsList(script, opts.entryName()),
+		ErrorOnly:      errorOnly(script, opts.entryName()),
+		Entry:          opts.entryName(),
+		TypeArgs:       typeArgsList(script, opts.entryName(), opts.typeArgs),
+		CommonTypes:    commonTypeNames(),
+		Pipe:           pipe,
+		NamedArg:       namedParam(script, opts.entryName()),
+		PipeArg:        pipeArg,
+	}
+	if opts.stdin != nil {
+		// requests come on a pipe after the extra files
+		data.RequestsFD = 3 + len(opts.extraFiles)
+	}
+	if opts.stdoutPipe() {
+		// followed by the pipes for responses and stdout
+		data.ResponsesFD = 3 + len(opts.extraFiles)
+		if opts.stdin != nil {
+			data.ResponsesFD++
+		}
+		data.StdoutFD = data.ResponsesFD + 1
+	}
+	if usesExec {
+		data.RestrictCommands = true
+		data.AllowedCommands = opts.allowedCommands
+	}
+	data.Imports = append(data.Imports, `goscriptfmt "fmt"`, `goscriptio "io"`, `goscriptdebug "runtime/debug"`, `goscriptsync "sync"`, `goscripttime "time"`)
+	for i := range args {
+		switch {
+		case args[i].Progress():
+			data.Progress = true
+		case args[i].Yield():
+			data.Yield = true
+		case args[i].Context():
+			data.Context = true
+		case !args[i].Deadline():
+			data.ArgsUsed = true
+		}
+	}
+	if data.Context || data.RestrictCommands {
+		data.Imports = append(data.Imports, `goscriptcontext "context"`)
+	}
+	if data.LeakCheck {
+		data.Imports = append(data.Imports, `goscriptruntime "runtime"`)
+	}
+	if data.NamedArg != "" && data.JSON {
+		data.Imports = append(data.Imports, `goscriptbytes "bytes"`)
+	} else if data.NamedArg != "" {
+		data.Imports = append(data.Imports, `goscriptreflect "reflect"`, `goscriptstrings "strings"`)
+	}
+	if data.Gzip {
+		data.Imports = append(data.Imports, `goscriptgzip "compress/gzip"`)
+	}
+	if data.JSON {
+		data.Imports = append(data.Imports, `goscriptjson "encoding/json"`)
+	} else {
+		data.Imports = append(data.Imports, `goscriptbytes "bytes"`)
+	}
+	// only provide RequestID to scripts that use it, so it can't
+	// collide with their own declarations
+	data.RequestID = usesRequestID(script)
+	data.Types = structTypes(script)
+	if opts.seccomp != nil {
+		var err error
+		if data.Seccomp, err = newSeccompProgram(*opts.seccomp); err != nil {
+			return err
+		}
+		data.Imports = append(data.Imports, `goscriptsyscall "syscall"`, `goscriptunsafe "unsafe"`)
+	}
+	if opts.limits != nil {
+		if err := checkResourceLimits(); err != nil {
+			return err
+		}
+		data.Limits = opts.limits
+		if data.Seccomp == nil {
+			data.Imports = append(data.Imports, `goscriptsyscall "syscall"`)
+		}
+	}
+	if data.StdoutFD != 0 {
+		if data.Seccomp == nil && data.Limits == nil {
+			data.Imports = append(data.Imports, `goscriptsyscall "syscall"`)
+		}
+		if data.JSON && data.NamedArg == "" {
+			data.Imports = append(data.Imports, `goscriptbytes "bytes"`)
+		}
+	}
+	return scriptHarnessTemplate.Execute(w, data)
+}
+
+// Source gets the generated Go program that runs the script, or an
+// empty string if the script failed to start (see GenerateSource).
+func (s *Script) Source() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.proc == nil {
+		return ""
+	}
+	return string(s.proc.source)
+}
+
+// GenerateSource gets the Go program that would be generated to run
+// the script with the options, without compiling it, which is useful
+// for seeing what the compiler saw when a script fails to start.
+// An error is returned if the program can't be generated, such as when
+// the script has no func goscript.
+func GenerateSource(script string, opts ...Option) (string, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	_, src, err := newProgram(script, o)
+	if err != nil {
+		return "", err
+	}
+	return string(src), nil
+}
+
+// UserLineRange gets the first and last lines of the generated program
+// (see Source) that hold the script, counting from 1. Both are zero if
+// the script failed to start.
+func (s *Script) UserLineRange() (start, end int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.proc == nil {
+		return 0, 0
+	}
+	return userLineRange(s.proc.source)
+}
+
+// userLineRange finds the lines between the <goscript> and
+// </goscript> markers in the generated source, after the line
+// directive that follows the opening marker.
+func userLineRange(src []byte) (start, end int) {
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	sc.Buffer(nil, len(src)+1)
+	line := 0
+	for sc.Scan() {
+		line++
+		switch sc.Text() {
+		case "// <goscript>":
+			start = line + 2
+		case "// </goscript>":
+			if start > 0 {
+				return start, line - 1
+			}
+		}
+	}
+	return 0, 0
+}
+
+// Close shuts down the script and cleans up any used resources.
+// On Unix, the script runs in a process group of its own, which Close
+// kills, so that processes started by the script are stopped too; on
+// other platforms, they are left running.
+// If the script process had already exited, such as by crashing or
+// calling os.Exit, the Error it exited with is returned, so its exit
+// code can be checked. Closing a script again does nothing, and
+// returns the same error.
+func (s *Script) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return s.closeErr
+	}
+	s.closed = true
+	if atomic.LoadInt32(&s.pending) == 1 {
+		// a script made by NewLazy that was never started is
+		// never started once closed
+		s.err = errors.New("goscript: the script is closed")
+		s.script = ""
+		atomic.StoreInt32(&s.pending, 0)
+	}
+	if s.proc != nil {
+		s.closeErr = s.proc.close()
+	}
+	if s.prog != nil {
+		if err := s.prog.Close(); err != nil && s.closeErr == nil {
+			s.closeErr = err
+		}
+	}
+	return s.closeErr
+}
+
+// processOutput tidies up compiler and runtime output.
+// Thanks to the line directives in the generated file, positions in
+// the script appear as goscript:line, and positions in the harness as
+// goscript.go:line; the temporary directory is removed from both.
+// Errors in the harness are usually knock-on effects of errors in the
+// script, so they are skipped if there are any of those.
+func processOutput(out []byte) string {
+	var lines, harnessLines []string
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		trimline := strings.TrimSpace(line)
+		if trimline == "# command-line-arguments" {
+			continue
+		}
+		line = scriptPathRegexp.ReplaceAllString(line, "$1")
+		if strings.HasPrefix(line, "goscript.go:") {
+			harnessLines = append(harnessLines, line)
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "goscript:") {
+		lines = append(lines, harnessLines...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// scriptPathRegexp matches paths to the generated file.
+var scriptPathRegexp = regexp.MustCompile(`[^\s:]*/(goscript(\.go)?:\d)`)
+
+// startingMarker is written by the script process as soon as it
+// starts, before the script's package-level variables are initialized
+// and its init functions run.
+const startingMarker = "\x00goscript:starting\n"
+
+// readyMarker is written by the script process once it is ready, and
+// before the protocol starts.
+// Anything before it on stdout is stray output from building or
+// initializing the script.
+const readyMarker = "\x00goscript:ready\n"
+
+// request is sent to the script process.
+type request struct {
+	// Register holds types to register before Args can be sent.
+	Register []typeRegistration
+	Args     []interface{}
+	// Raw asks for the response value to be encoded into
+	// response.Raw.
+	Raw bool
+	// RequestID identifies the call, for the script's RequestID
+	// function.
+	RequestID string
+	// Stream asks for the response value, an io.Reader, to be
+	// read and sent back in chunks.
+	Stream bool
+	// More is set if more Args follow in another request, which
+	// has only Args and More set.
+	More bool
+	// Typed asks for the response value to be encoded on its own
+	// into response.Raw, so it can be decoded into a value of its
+	// type.
+	Typed bool
+	// Deadline is the deadline of the caller's context, if it has
+	// one.
+	Deadline time.Time
+	// Func names the entry point to call, if it isn't func goscript.
+	Func string
+	// Ping asks for an empty response, to check the script is
+	// answering.
+	Ping bool
+	// Yield asks for the values the script yields to be sent back
+	// as they are yielded.
+	Yield bool
+	// Batch holds the Args of several calls to make, in place of
+	// Args, whose responses are sent back together.
+	Batch [][]interface{}
+	// Named is set if Args holds a map of named parameters, to set
+	// the fields of the struct func goscript takes.
+	Named bool
+	// Ack tells the script the caller has received another
+	// streamWindow chunks or yielded values, so it can send more.
+	Ack bool
+}
+
+// response is sent back from the script process.
+type response struct {
+	Value interface{}
+	Error error
+	// ErrorText holds the message of the error returned by the
+	// script, if the error can't be encoded, such as with the JSON
+	// codec, or when its type isn't registered with gob.
+	ErrorText string
+	// Err is set when the request itself could not be handled.
+	Err string
+	// Raw holds the encoded value for raw requests.
+	Raw []byte
+	// IsProgress is set for progress updates sent while a call
+	// is running, in which case only Progress is meaningful.
+	Progress   int
+	IsProgress bool
+	// Panicked is set if the goscript function panicked, with
+	// the value and stack in Panic and Stack.
+	Panicked bool
+	Panic    string
+	Stack    string
+	// IsChunk is set for chunks of a streamed value, in which case
+	// only Chunk is meaningful.
+	Chunk   []byte
+	IsChunk bool
+	// Goroutines is how many more goroutines the script process
+	// had after the call than before it, if leaks are checked.
+	Goroutines int
+	// IsOutput is set for output written by the script to
+	// os.Stdout, in which case only Output is meaningful.
+	Output   []byte
+	IsOutput bool
+	// MultiValue is set if Value holds the several values returned
+	// by the goscript function, as a []interface{}.
+	MultiValue bool
+	// IsYield is set for values yielded by the script while a call
+	// is running, in which case only Value is meaningful.
+	IsYield bool
+	// Batch holds the responses to the calls in a batch request.
+	Batch []response
+}
+
+var scriptHarnessTemplate *template.Template
+var scriptHarnessTemplateErr error
+
+// scriptStartLine is the line of the generated file that the script
+// starts on.
+var scriptStartLine int
+
+func init() {
+	scriptHarnessTemplate, scriptHarnessTemplateErr = template.New("goscript").Parse(scriptHarnessCode)
+	s := bufio.NewScanner(strings.NewReader(scriptHarnessCode))
+	line := 1
+	for s.Scan() {
+		if s.Text() == "{{ .Goscript }}" {
+			break
+		}
+		line++
+	}
+	scriptStartLine = line
+}
+
+var scriptHarnessCode = `// Code generated by goscript; DO NOT EDIT
+// github.com/matryer/goscript
+
+package main
+
+import (
+	goscriptgob "encoding/gob"
+	goscriptos "os"
+	goscriptlog "log"
+	{{ range .Imports }}{{ . }}; {{ end }}
+)
+
+// <goscript>
+//line goscript:1:1
+{{ .Goscript }}
+// </goscript>
+//line goscript.go:{{ .HarnessLine }}:1
+
+// goscriptStart prepares the process to run the script. It is called
+// by a variable declared first in the file whose variables are
+// initialized first (see insertStart), so it runs before the script's
+// package-level variables are initialized, as long as it doesn't use
+// any of its own.
+func goscriptStart() bool {
+	// report that the process has started, before the script's
+	// initialization
+	goscriptWriteMarker({{ printf "%q" .StartingMarker }})
+	{{- if .Limits }}
+	goscriptSetLimits()
+	{{- end }}
+	{{- if .Seccomp }}
+	goscriptSeccomp()
+	{{- end }}
+	return true
+}
+{{- if .Pipe }}
+
+// goscriptPiping reports whether the process was started by Pipe, to
+// call the script's filter with its stdin and stdout.
+func goscriptPiping() bool {
+	return len(goscriptos.Args) > 1 && goscriptos.Args[1] == {{ printf "%q" .PipeArg }}
+}
+{{- end }}
+
+// goscriptRequests is where requests are read from, which is stdin,
+// unless the script has its own stdin.
+{{- if .RequestsFD }}
+var goscriptRequests = goscriptos.NewFile({{ .RequestsFD }}, "goscript")
+{{- else }}
+var goscriptRequests = goscriptos.Stdin
+{{- end }}
+
+// goscriptResponses is where responses are written, which is stdout,
+// unless stdout is the result of calls.
+{{- if .ResponsesFD }}
+var goscriptResponses = goscriptos.NewFile({{ .ResponsesFD }}, "goscript")
+{{- else }}
+var goscriptResponses = goscriptos.Stdout
+{{- end }}
+
+func main() {
+	{{- if .Pipe }}
+	if goscriptPiping() {
+		if err := {{ .Entry }}(goscriptos.Stdin, goscriptos.Stdout); err != nil {
+			goscriptfmt.Fprintln(goscriptos.Stderr, err)
+			goscriptos.Exit(1)
+		}
+		return
+	}
+	{{- end }}
+	{{- if .Gzip }}
+	goscriptWriteMarker({{ printf "%q" .ReadyMarker }})
+	// the host starts its stream with the first request
+	goscriptIn, err := goscriptgzip.NewReader(goscriptRequests)
+	if err != nil {
+		goscriptlog.Fatalln(err)
+	}
+	goscriptOut := goscriptgzip.NewWriter(goscriptResponses)
+	{{- if .JSON }}
+	r := goscriptjson.NewDecoder(goscriptIn)
+	goscriptW := goscriptFlushEncoder{goscriptjson.NewEncoder(goscriptOut), goscriptOut}
+	{{- else }}
+	r := goscriptgob.NewDecoder(goscriptIn)
+	goscriptW := goscriptFlushEncoder{goscriptgob.NewEncoder(goscriptOut), goscriptOut}
+	{{- end }}
+	{{- else if .JSON }}
+	r := goscriptjson.NewDecoder(goscriptRequests)
+	goscriptWriteMarker({{ printf "%q" .ReadyMarker }})
+	goscriptW := goscriptjson.NewEncoder(goscriptResponses)
+	{{- else }}
+	r := goscriptgob.NewDecoder(goscriptRequests)
+	goscriptWriteMarker({{ printf "%q" .ReadyMarker }})
+	goscriptW := goscriptgob.NewEncoder(goscriptResponses)
+	{{- end }}
+	w := &goscriptLockedEncoder{enc: goscriptW}
+	{{- if .Progress }}
+	goscriptProgressW = w
+	{{- end }}
+	{{- if .Yield }}
+	goscriptYieldW, goscriptYieldR = w, r
+	{{- end }}
+	{{- if .StdoutFD }}
+	// stdout is read back as the result of each call
+	goscriptReadStdout(w)
+	{{- else }}
+	// keep the script's writes away from the protocol stream
+	goscriptForwardOutput(w)
+	{{- end }}
+	for {
+		var req goscriptRequest
+		if err := r.Decode(&req); err != nil {
+			goscriptlog.Fatalln(err)
+		}
+		if req.Ack {
+			// the stream it was for ended without waiting for it
+			continue
+		}
+		for more := req.More; more; {
+			var next goscriptRequest
+			if err := r.Decode(&next); err != nil {
+				goscriptlog.Fatalln(err)
+			}
+			req.Args = append(req.Args, next.Args...)
+			more = next.More
+		}
+		if len(req.Register) > 0 {
+			var res goscriptResponse
+			res.Err = goscriptRegister(req.Register)
+			if err := w.Encode(res); err != nil {
+				goscriptlog.Fatalln(err)
+			}
+			continue
+		}
+		if req.Ping {
+			if err := w.Encode(goscriptResponse{}); err != nil {
+				goscriptlog.Fatalln(err)
+			}
+			continue
+		}
+		{{- if .RequestID }}
+		goscriptRequestID = req.RequestID
+		{{- end }}
+		{{- if .Yield }}
+		goscriptYieldLock.Lock()
+		goscriptYielding, goscriptYielded = req.Yield, 0
+		goscriptYieldLock.Unlock()
+		{{- end }}
+		var res goscriptResponse
+		if req.Batch != nil {
+			res = goscriptCallBatch(req)
+		} else {
+			res = goscriptCall(req)
+		}
+		{{- if .Yield }}
+		// values yielded once the call has returned are dropped
+		goscriptYieldLock.Lock()
+		goscriptYielding = false
+		goscriptYieldLock.Unlock()
+		{{- end }}
+		if (req.Raw || req.Typed) && res.Err == "" && !res.Panicked && res.Error == nil {
+			{{- if .JSON }}
+			var err error
+			if res.Raw, err = goscriptjson.Marshal(res.Value); err != nil {
+				res.Err = err.Error()
+			}
+			res.Value = nil
+			{{- else }}
+			var v interface{} = struct{ Value interface{} }{res.Value}
+			if req.Typed {
+				v = res.Value
+			}
+			var buf goscriptbytes.Buffer
+			if v != nil {
+				if err := goscriptgob.NewEncoder(&buf).Encode(v); err != nil {
+					res.Err = err.Error()
+				}
+			}
+			res.Value, res.Raw = nil, buf.Bytes()
+			{{- end }}
+		}
+		if req.Stream && res.Err == "" && !res.Panicked && res.Error == nil {
+			res = goscriptStream(w, r, res)
+		}
+		goscriptSendableError(&res)
+		{{- if .Progress }}
+		goscriptProgressLock.Lock()
+		goscriptProgressLast = -1
+		{{- end }}
+		if err := w.Encode(res); err != nil {
+			goscriptlog.Fatalln(err)
+		}
+		{{- if .Progress }}
+		goscriptProgressLock.Unlock()
+		{{- end }}
+	}
+}
+// goscriptCall makes the call the request asks for.
+func goscriptCall(goscriptReq goscriptRequest) (goscriptRes goscriptResponse) {
+	{{- if .Entries }}
+	if goscriptReq.Func != "" {
+		return goscriptCallEntry(goscriptReq)
+	}
+	{{- end }}
+	{{- if .ArgsUsed }}
+	goscriptArgs := goscriptReq.Args
+	var goscriptArgErr error
+	{{- end }}
+	{{- range .InArgs }}
+	{{- if .Progress }}
+	{{ .Name }} := goscriptSetProgress
+	{{- else if .Yield }}
+	{{ .Name }} := goscriptYield
+	{{- else if .Deadline }}
+	{{ .Name }} := goscriptReq.Deadline
+	{{- else if .Context }}
+	{{ .Name }}, goscriptCancel := goscriptContext(goscriptReq.Deadline)
+	{{- else if eq .Name $.NamedArg }}
+	var {{ .Name }} {{ .Typename }}
+	if goscriptReq.Named {
+		goscriptSetNamed(&goscriptArgErr, "{{ .Name }}", goscriptArgs[{{ .ArgIndex }}], &{{ .Name }})
+	} else {
+		{{- if $.JSON }}
+		goscriptUnmarshalArg(&goscriptArgErr, "{{ .Name }}", goscriptArgs[{{ .ArgIndex }}], &{{ .Name }})
+		{{- else }}
+		var goscriptOK bool
+		{{ .Name }}, goscriptOK = goscriptArgs[{{ .ArgIndex }}].({{ .Typename }})
+		goscriptCheckArg(&goscriptArgErr, goscriptOK, "{{ .Name }}", goscriptArgs[{{ .ArgIndex }}], "{{ .Typename }}")
+		{{- end }}
+	}
+	{{- else if and $.JSON .Variadic }}
+	{{ .Name }} := make({{ .Typename }}, len(goscriptArgs)-{{ .ArgIndex }})
+	for goscriptI := {{ .ArgIndex }}; goscriptI < len(goscriptArgs); goscriptI++ {
+		goscriptUnmarshalArg(&goscriptArgErr, "{{ .Name }}", goscriptArgs[goscriptI], &{{ .Name }}[goscriptI-{{ .ArgIndex }}])
+	}
+	{{- else if $.JSON }}
+	var {{ .Name }} {{ .Typename }}
+	goscriptUnmarshalArg(&goscriptArgErr, "{{ .Name }}", goscriptArgs[{{ .ArgIndex }}], &{{ .Name }})
+	{{- else if .Variadic }}
+	{{ .Name }} := make({{ .Typename }}, len(goscriptArgs)-{{ .ArgIndex }})
+	for goscriptI := {{ .ArgIndex }}; goscriptI < len(goscriptArgs); goscriptI++ {
+		var goscriptOK bool
+		{{ .Name }}[goscriptI-{{ .ArgIndex }}], goscriptOK = goscriptArgs[goscriptI].({{ .TypenameSingular }})
+		goscriptCheckArg(&goscriptArgErr, goscriptOK, "{{ .Name }}", goscriptArgs[goscriptI], "{{ .TypenameSingular }}")
+	}
+	{{- else }}
+	{{ .Name }}, goscriptOK := goscriptArgs[{{ .ArgIndex }}].({{ .Typename }})
+	goscriptCheckArg(&goscriptArgErr, goscriptOK, "{{ .Name }}", goscriptArgs[{{ .ArgIndex }}], "{{ .Typename }}")
+	{{- end }}
+	{{- end }}
+	{{- if .ArgsUsed }}
+	if goscriptArgErr != nil {
+		goscriptRes.Err = goscriptArgErr.Error()
+		return goscriptRes
+	}
+	{{- end }}
+	{{- if .LeakCheck }}
+	goscriptGoroutines := goscriptruntime.NumGoroutine()
+	{{- end }}
+	func() {
+		defer func() {
+			if goscriptR := recover(); goscriptR != nil {
+				goscriptRes.Panicked = true
+				goscriptRes.Panic = goscriptfmt.Sprint(goscriptR)
+				goscriptRes.Stack = string(goscriptdebug.Stack())
+			}
+		}()
+		{{- if .Context }}
+		defer goscriptCancel()
+		{{- end }}
+		{{- if .Pipe }}
+		goscriptRes.Err = "goscript: func {{ .Entry }} is a filter; use Pipe"
+		{{- else if .StdoutResult }}
+		goscriptRes.Value, goscriptRes.Error = goscriptCaptureStdout(func() error {
+			return {{ .Entry }}{{ .TypeArgs }}({{ .ArgsList }})
+		})
+		{{- else if .Results }}
+		{{ .Results }}, goscriptErr := {{ .Entry }}{{ .TypeArgs }}({{ .ArgsList }})
+		goscriptRes.Value, goscriptRes.Error = []interface{}{ {{- .Results -}} }, goscriptErr
+		goscriptRes.MultiValue = true
+		{{- else if .ErrorOnly }}
+		goscriptRes.Error = {{ .Entry }}{{ .TypeArgs }}({{ .ArgsList }})
+		{{- else }}
+		goscriptRes.Value, goscriptRes.Error = {{ .Entry }}{{ .TypeArgs }}({{ .ArgsList }})
+		{{- end }}
+	}()
+	{{- if .LeakCheck }}
+	goscriptRes.Goroutines = goscriptruntime.NumGoroutine() - goscriptGoroutines
+	{{- end }}
+	return goscriptRes
+}
+
+// goscriptCallBatch makes a call for each set of arguments in the
+// batch, and returns the responses together.
+func goscriptCallBatch(req goscriptRequest) goscriptResponse {
+	res := goscriptResponse{Batch: make([]goscriptResponse, len(req.Batch))}
+	for i, args := range req.Batch {
+		call := req
+		call.Args, call.Batch = args, nil
+		callRes := goscriptCall(call)
+		goscriptSendableError(&callRes)
+		res.Goroutines += callRes.Goroutines
+		res.Batch[i] = callRes
+	}
+	return res
+}
+
+// goscriptSendableError replaces the error in the response with its
+// message if it can't be encoded, so that the response can be sent.
+func goscriptSendableError(res *goscriptResponse) {
+	if res.Error == nil {
+		return
+	}
+	{{- if not .JSON }}
+	// errors of types registered with gob, such as with Register,
+	// are sent as they are; others, such as those made by
+	// errors.New, aren't registered
+	check := struct{ Error error }{res.Error}
+	if goscriptgob.NewEncoder(goscriptio.Discard).Encode(check) == nil {
+		return
+	}
+	{{- end }}
+	res.ErrorText, res.Error = res.Error.Error(), nil
+}
+{{- if .Progress }}
+
+var (
+	goscriptProgressLock goscriptsync.Mutex
+	goscriptProgressW    goscriptEncoder
+	goscriptProgressLast = -1
+)
+
+// goscriptSetProgress reports the progress of the current call.
+func goscriptSetProgress(n int) {
+	goscriptProgressLock.Lock()
+	defer goscriptProgressLock.Unlock()
+	if n == goscriptProgressLast {
+		return
+	}
+	goscriptProgressLast = n
+	if err := goscriptProgressW.Encode(goscriptResponse{Progress: n, IsProgress: true}); err != nil {
+		goscriptlog.Fatalln(err)
+	}
+}
+{{- end }}
+{{- if .Yield }}
+
+var (
+	goscriptYieldW goscriptEncoder
+	goscriptYieldR goscriptDecoder
+	// goscriptYieldLock guards goscriptYielding, and
+	// goscriptYielded, which counts the values yielded in the
+	// current call.
+	goscriptYieldLock goscriptsync.Mutex
+	goscriptYielding  bool
+	goscriptYielded   int
+)
+
+// goscriptYield sends a value to the caller of ExecuteStream, and
+// drops values yielded during other calls.
+func goscriptYield(v interface{}) {
+	goscriptYieldLock.Lock()
+	defer goscriptYieldLock.Unlock()
+	if !goscriptYielding {
+		return
+	}
+	if goscriptYielded > 0 && goscriptYielded%{{ .StreamWindow }} == 0 {
+		goscriptAwaitAck(goscriptYieldR)
+	}
+	if err := goscriptYieldW.Encode(goscriptResponse{Value: v, IsYield: true}); err != nil {
+		goscriptlog.Fatalln(err)
+	}
+	goscriptYielded++
+}
+{{- end }}
+
+{{- if .RestrictCommands }}
+
+// goscriptAllowedCommands holds the commands the script can run.
+var goscriptAllowedCommands = map[string]bool{
+	{{- range .AllowedCommands }}
+	{{ printf "%q" . }}: true,
+	{{- end }}
+}
+
+// goscriptCommand is called in place of exec.Command.
+func goscriptCommand(name string, arg ...string) *goscriptosexec.Cmd {
+	return goscriptAllowCommand(goscriptosexec.Command(name, arg...), name)
+}
+
+// goscriptCommandContext is called in place of exec.CommandContext.
+func goscriptCommandContext(ctx goscriptcontext.Context, name string, arg ...string) *goscriptosexec.Cmd {
+	return goscriptAllowCommand(goscriptosexec.CommandContext(ctx, name, arg...), name)
+}
+
+// goscriptAllowCommand makes cmd fail to start if it isn't allowed.
+func goscriptAllowCommand(cmd *goscriptosexec.Cmd, name string) *goscriptosexec.Cmd {
+	if !goscriptAllowedCommands[name] {
+		cmd.Err = goscriptfmt.Errorf("goscript: command %q is not allowed", name)
+	}
+	return cmd
+}
+{{- end }}
+{{- if .Context }}
+
+// goscriptContext makes the context for the call, which has the
+// caller's deadline if it set one.
+func goscriptContext(deadline goscripttime.Time) (goscriptcontext.Context, goscriptcontext.CancelFunc) {
+	if deadline.IsZero() {
+		return goscriptcontext.WithCancel(goscriptcontext.Background())
+	}
+	return goscriptcontext.WithDeadline(goscriptcontext.Background(), deadline)
+}
+{{- end }}
+
+{{- if .Entries }}
+
+// goscriptCallEntry calls the entry point named by the request.
+func goscriptCallEntry(goscriptReq goscriptRequest) (goscriptRes goscriptResponse) {
+	defer func() {
+		if goscriptR := recover(); goscriptR != nil {
+			goscriptRes.Panicked = true
+			goscriptRes.Panic = goscriptfmt.Sprint(goscriptR)
+			goscriptRes.Stack = string(goscriptdebug.Stack())
+		}
+	}()
+	switch goscriptReq.Func {
+	{{- range .Entries }}
+	case {{ printf "%q" .Name }}:
+		var goscriptArgErr error
+		{{- range .Params }}
+		{{- if and $.JSON .Variadic }}
+		{{ .Name }} := make({{ .Typename }}, len(goscriptReq.Args)-{{ .ArgIndex }})
+		for goscriptI := {{ .ArgIndex }}; goscriptI < len(goscriptReq.Args); goscriptI++ {
+			goscriptUnmarshalArg(&goscriptArgErr, "{{ .Name }}", goscriptReq.Args[goscriptI], &{{ .Name }}[goscriptI-{{ .ArgIndex }}])
+		}
+		{{- else if $.JSON }}
+		var {{ .Name }} {{ .Typename }}
+		goscriptUnmarshalArg(&goscriptArgErr, "{{ .Name }}", goscriptReq.Args[{{ .ArgIndex }}], &{{ .Name }})
+		{{- else if .Variadic }}
+		{{ .Name }} := make({{ .Typename }}, len(goscriptReq.Args)-{{ .ArgIndex }})
+		for goscriptI := {{ .ArgIndex }}; goscriptI < len(goscriptReq.Args); goscriptI++ {
+			var goscriptOK bool
+			{{ .Name }}[goscriptI-{{ .ArgIndex }}], goscriptOK = goscriptReq.Args[goscriptI].({{ .TypenameSingular }})
+			goscriptCheckArg(&goscriptArgErr, goscriptOK, "{{ .Name }}", goscriptReq.Args[goscriptI], "{{ .TypenameSingular }}")
+		}
+		{{- else }}
+		{{ .Name }}, goscriptOK := goscriptReq.Args[{{ .ArgIndex }}].({{ .Typename }})
+		goscriptCheckArg(&goscriptArgErr, goscriptOK, "{{ .Name }}", goscriptReq.Args[{{ .ArgIndex }}], "{{ .Typename }}")
+		{{- end }}
+		{{- end }}
+		if goscriptArgErr != nil {
+			goscriptRes.Err = goscriptArgErr.Error()
+			return goscriptRes
+		}
+		{{- if $.StdoutResult }}
+		goscriptRes.Value, goscriptRes.Error = goscriptCaptureStdout(func() error {
+			return goscript_{{ .Name }}({{ .ArgsList }})
+		})
+		{{- else if .ErrorOnly }}
+		goscriptRes.Error = goscript_{{ .Name }}({{ .ArgsList }})
+		{{- else }}
+		goscriptRes.Value, goscriptRes.Error = goscript_{{ .Name }}({{ .ArgsList }})
+		{{- end }}
+	{{- end }}
+	default:
+		goscriptRes.Err = goscriptfmt.Sprintf("goscript: the script has no func goscript_%s", goscriptReq.Func)
+	}
+	return goscriptRes
+}
+{{- end }}
+{{- if .NoGoscript }}
+
+// {{ .Entry }} stands in for the script's, which only has other entry
+// points; the host doesn't call it.
+{{- if .StdoutResult }}
+func {{ .Entry }}() error {
+	return nil
+}
+{{- else }}
+func {{ .Entry }}() (interface{}, error) {
+	return nil, nil
+}
+{{- end }}
+{{- end }}
+
+// goscriptEncoder is implemented by the gob and json encoders.
+type goscriptEncoder interface {
+	Encode(v interface{}) error
+}
+
+type goscriptDecoder interface {
+	Decode(v interface{}) error
+}
+
+// goscriptAwaitAck waits for the caller to acknowledge the chunks or
+// values streamed so far, so it is never more than a window behind.
+func goscriptAwaitAck(acks goscriptDecoder) {
+	var req goscriptRequest
+	if err := acks.Decode(&req); err != nil {
+		goscriptlog.Fatalln(err)
+	}
+	if !req.Ack {
+		goscriptlog.Fatalln("goscript: got a request while streaming")
+	}
+}
+
+// goscriptLockedEncoder lets the script's output be sent alongside
+// responses.
+type goscriptLockedEncoder struct {
+	mu  goscriptsync.Mutex
+	enc goscriptEncoder
+}
+
+func (e *goscriptLockedEncoder) Encode(v interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(v)
+}
+
+// goscriptForwardOutput points os.Stdout at a pipe, and sends what is
+// written to it to the host as it arrives.
+func goscriptForwardOutput(w goscriptEncoder) {
+	r, pw, err := goscriptos.Pipe()
+	if err != nil {
+		goscriptlog.Fatalln(err)
+	}
+	goscriptos.Stdout = pw
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				if err := w.Encode(goscriptResponse{Output: buf[:n], IsOutput: true}); err != nil {
+					goscriptlog.Fatalln(err)
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+{{- if .Gzip }}
+
+// goscriptFlushEncoder flushes the compressed stream after each
+// value, so the host can decode it straight away.
+type goscriptFlushEncoder struct {
+	enc goscriptEncoder
+	zw  *goscriptgzip.Writer
+}
+
+func (e goscriptFlushEncoder) Encode(v interface{}) error {
+	if err := e.enc.Encode(v); err != nil {
+		return err
+	}
+	return e.zw.Flush()
+}
+{{- end }}
+{{- if and .JSON (or .ArgsUsed .Entries) }}
+
+// goscriptUnmarshalArg decodes an argument, unless an earlier one
+// has already failed.
+func goscriptUnmarshalArg(errp *error, name string, data goscriptjson.RawMessage, v interface{}) {
+	if *errp != nil {
+		return
+	}
+	if err := goscriptjson.Unmarshal(data, v); err != nil {
+		*errp = goscriptfmt.Errorf("goscript: argument %s: %v", name, err)
+	}
+}
+{{- else if or .ArgsUsed .Entries }}
+
+// goscriptCheckArg records an error for an argument that isn't of the
+// parameter's type, unless an earlier one has already failed.
+func goscriptCheckArg(errp *error, ok bool, name string, v interface{}, typ string) {
+	if ok || *errp != nil {
+		return
+	}
+	*errp = goscriptfmt.Errorf("goscript: argument %s: got %T, want %s", name, v, typ)
+}
+{{- end }}
+{{- if and .NamedArg .JSON }}
+
+// goscriptSetNamed sets the fields of the struct v points to from the
+// named values in data, for ExecuteNamed, unless an earlier argument
+// has already failed.
+func goscriptSetNamed(errp *error, name string, data goscriptjson.RawMessage, v interface{}) {
+	if *errp != nil {
+		return
+	}
+	dec := goscriptjson.NewDecoder(goscriptbytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		*errp = goscriptfmt.Errorf("goscript: argument %s: %v", name, err)
+	}
+}
+{{- else if .NamedArg }}
+
+// goscriptSetNamed sets the fields of the struct v points to from the
+// named values in arg, for ExecuteNamed, unless an earlier argument
+// has already failed.
+func goscriptSetNamed(errp *error, name string, arg interface{}, v interface{}) {
+	if *errp != nil {
+		return
+	}
+	named, ok := arg.(map[string]interface{})
+	if !ok {
+		*errp = goscriptfmt.Errorf("goscript: argument %s: got %T, want named parameters", name, arg)
+		return
+	}
+	s := goscriptreflect.ValueOf(v).Elem()
+	for key, value := range named {
+		field := s.FieldByName(key)
+		if !field.IsValid() {
+			field = s.FieldByNameFunc(func(f string) bool {
+				return goscriptstrings.EqualFold(f, key)
+			})
+		}
+		if !field.IsValid() || !field.CanSet() {
+			*errp = goscriptfmt.Errorf("goscript: argument %s: %s has no field %s", name, s.Type(), key)
+			return
+		}
+		if value == nil {
+			continue
+		}
+		val := goscriptreflect.ValueOf(value)
+		if !val.Type().AssignableTo(field.Type()) {
+			*errp = goscriptfmt.Errorf("goscript: argument %s: field %s: got %T, want %s", name, key, value, field.Type())
+			return
+		}
+		field.Set(val)
+	}
+}
+{{- end }}
+
+// goscriptWriteMarker writes a handshake marker to stdout.
+func goscriptWriteMarker(marker string) bool {
+	{{- if .Pipe }}
+	if goscriptPiping() {
+		// stdout is the filter's output
+		return false
+	}
+	{{- end }}
+	{{- if .ResponsesFD }}
+	// written straight to the descriptor, since this is called
+	// before the package's variables are initialized
+	_, err := goscriptsyscall.Write({{ .ResponsesFD }}, []byte(marker))
+	{{- else }}
+	_, err := goscriptos.Stdout.WriteString(marker)
+	{{- end }}
+	if err != nil {
+		goscriptlog.Fatalln(err)
+	}
+	return true
+}
+
+// goscriptStream sends the io.Reader in res.Value in chunks, waiting
+// for the caller's acknowledgements from acks as it goes, and returns
+// the final response.
+func goscriptStream(w goscriptEncoder, acks goscriptDecoder, res goscriptResponse) goscriptResponse {
+	r, ok := res.Value.(goscriptio.Reader)
+	if !ok {
+		res.Err = goscriptfmt.Sprintf("goscript: script returned %T, not an io.Reader", res.Value)
+		res.Value = nil
+		return res
+	}
+	res.Value = nil
+	if c, ok := r.(goscriptio.Closer); ok {
+		defer c.Close()
+	}
+	buf := make([]byte, 32*1024)
+	for sent := 0; ; {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if sent > 0 && sent%{{ .StreamWindow }} == 0 {
+				goscriptAwaitAck(acks)
+			}
+			{{- if .Progress }}
+			goscriptProgressLock.Lock()
+			{{- end }}
+			err := w.Encode(goscriptResponse{Chunk: buf[:n], IsChunk: true})
+			{{- if .Progress }}
+			goscriptProgressLock.Unlock()
+			{{- end }}
+			if err != nil {
+				goscriptlog.Fatalln(err)
+			}
+			sent++
+		}
+		if err == goscriptio.EOF {
+			return res
+		}
+		if err != nil {
+			res.Err = "goscript: reading result: " + err.Error()
+			return res
+		}
+	}
+}
+{{- if .StdoutFD }}
+
+// goscriptStdoutMarker is written to stdout to mark the end of what
+// has been written so far.
+const goscriptStdoutMarker = "\x00goscript:stdout\n"
+
+var (
+	// goscriptStdoutW sends what is written to stdout outside calls
+	// to the host.
+	goscriptStdoutW goscriptEncoder
+	// goscriptStdoutBuf holds what has been read from stdout but not
+	// yet returned by goscriptSyncStdout, and goscriptStdoutCond is
+	// signalled when it grows.
+	goscriptStdoutLock goscriptsync.Mutex
+	goscriptStdoutCond = goscriptsync.NewCond(&goscriptStdoutLock)
+	goscriptStdoutBuf  []byte
+)
+
+// goscriptReadStdout reads what is written to stdout from the read
+// end of its pipe, which the host gives the script too, so writes
+// through any copy of the descriptor are read, such as by programs
+// the script runs.
+func goscriptReadStdout(w goscriptEncoder) {
+	goscriptStdoutW = w
+	// programs the script runs only inherit stdout itself
+	goscriptsyscall.CloseOnExec({{ .ResponsesFD }})
+	goscriptsyscall.CloseOnExec({{ .StdoutFD }})
+	r := goscriptos.NewFile({{ .StdoutFD }}, "stdout")
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := r.Read(buf)
+			goscriptStdoutLock.Lock()
+			goscriptStdoutBuf = append(goscriptStdoutBuf, buf[:n]...)
+			goscriptStdoutCond.Broadcast()
+			goscriptStdoutLock.Unlock()
+			if err != nil {
+				goscriptlog.Fatalln(err)
+			}
+		}
+	}()
+}
+
+// goscriptSyncStdout marks the end of what has been written to stdout,
+// and returns it once it has been read.
+func goscriptSyncStdout() []byte {
+	marker := []byte(goscriptStdoutMarker)
+	if _, err := goscriptsyscall.Write(1, marker); err != nil {
+		goscriptlog.Fatalln(err)
+	}
+	goscriptStdoutLock.Lock()
+	defer goscriptStdoutLock.Unlock()
+	for {
+		if i := goscriptbytes.Index(goscriptStdoutBuf, marker); i >= 0 {
+			out := goscriptStdoutBuf[:i:i]
+			goscriptStdoutBuf = goscriptStdoutBuf[i+len(marker):]
+			return out
+		}
+		goscriptStdoutCond.Wait()
+	}
+}
+
+// goscriptCaptureStdout calls fn and returns everything written to
+// stdout while it ran, sending what was written before to the host.
+func goscriptCaptureStdout(fn func() error) (string, error) {
+	if out := goscriptSyncStdout(); len(out) > 0 {
+		if err := goscriptStdoutW.Encode(goscriptResponse{Output: out, IsOutput: true}); err != nil {
+			goscriptlog.Fatalln(err)
+		}
+	}
+	err := fn()
+	return string(goscriptSyncStdout()), err
+}
+{{- else if .StdoutResult }}
+
+// goscriptCaptureStdout calls fn and returns everything it wrote
+// to os.Stdout.
+func goscriptCaptureStdout(fn func() error) (string, error) {
+	r, w, err := goscriptos.Pipe()
+	if err != nil {
+		return "", err
+	}
+	stdout := goscriptos.Stdout
+	goscriptos.Stdout = w
+	defer func() {
+		goscriptos.Stdout = stdout
+		w.Close()
+	}()
+	out := make(chan []byte, 1)
+	go func() {
+		var b []byte
+		buf := make([]byte, 4096)
+		for {
+			n, err := r.Read(buf)
+			b = append(b, buf[:n]...)
+			if err != nil {
+				break
+			}
+		}
+		r.Close()
+		out <- b
+	}()
+	err = fn()
+	goscriptos.Stdout = stdout
+	w.Close()
+	return string(<-out), err
+}
+{{- end }}
+
+{{- if .RequestID }}
+
+// goscriptRequestID is the ID of the current call.
+var goscriptRequestID string
+
+// RequestID gets the ID of the current call, as set on the caller's
+// context with goscript.ContextWithRequestID.
+func RequestID() string {
+	return goscriptRequestID
+}
+{{- end }}
+
+
+{{- if not .JSON }}
+
+func init() {
+	// common types are registered by the host too, so values of
+	// them can be sent as interface{} values
+	{{- range .CommonTypes }}
+	goscriptgob.Register({{ . }}(nil))
+	{{- end }}
+}
+{{- end }}
+
+// goscriptTypes holds the struct types declared in the script, and
+// pointers to them.
+var goscriptTypes = map[string][2]interface{}{
+	{{- range .Types }}
+	"{{ . }}": {{"{"}}{{ . }}{}, &{{ . }}{}},
+	{{- end }}
+}
+
+// goscriptRegister registers script types under the names used
+// by the caller, returning a message if any are unknown.
+func goscriptRegister(regs []goscriptRegistration) string {
+	for _, reg := range regs {
+		v, ok := goscriptTypes[reg.Type]
+		if !ok {
+			return "goscript: type " + reg.Type + " is not declared in the script"
+		}
+		if reg.Ptr {
+			goscriptgob.RegisterName(reg.Name, v[1])
+		} else {
+			goscriptgob.RegisterName(reg.Name, v[0])
+		}
+	}
+	return ""
+}
+{{- if .Limits }}
+
+// goscriptSetLimits sets the limits of the process.
+func goscriptSetLimits() {
+	{{- if gt .Limits.MaxMemoryBytes 0 }}
+	goscriptSetLimit(goscriptsyscall.RLIMIT_DATA, {{ .Limits.MaxMemoryBytes }})
+	{{- end }}
+	{{- if gt .Limits.MaxCPUSeconds 0 }}
+	// the kernel kills the process once it reaches the hard limit
+	goscriptSetLimit(goscriptsyscall.RLIMIT_CPU, {{ .Limits.MaxCPUSeconds }})
+	{{- end }}
+}
+
+func goscriptSetLimit(resource int, max uint64) {
+	limit := goscriptsyscall.Rlimit{Cur: max, Max: max}
+	if err := goscriptsyscall.Setrlimit(resource, &limit); err != nil {
+		goscriptlog.Fatalln("goscript: setrlimit:", err)
+	}
+}
+{{- end }}
+{{- if .Seccomp }}
+
+type goscriptSockFilter struct {
+	code   uint16
+	jt, jf uint8
+	k      uint32
+}
+
+type goscriptSockFprog struct {
+	len    uint16
+	filter *goscriptSockFilter
+}
+
+// goscriptSeccomp installs the seccomp filter on every thread.
+func goscriptSeccomp() {
+	filter := []goscriptSockFilter{
+		{{- range .Seccomp.Filter }}
+		{ {{- .Code }}, {{ .Jt }}, {{ .Jf }}, {{ .K -}} },
+		{{- end }}
+	}
+	// load the local time zone while files can still be opened
+	goscripttime.Local.String()
+	// PR_SET_NO_NEW_PRIVS
+	if _, _, errno := goscriptsyscall.RawSyscall(goscriptsyscall.SYS_PRCTL, 38, 1, 0); errno != 0 {
+		goscriptlog.Fatalln("goscript: seccomp:", errno)
+	}
+	prog := goscriptSockFprog{
+		len:    uint16(len(filter)),
+		filter: &filter[0],
+	}
+	// SECCOMP_SET_MODE_FILTER, SECCOMP_FILTER_FLAG_TSYNC
+	if _, _, errno := goscriptsyscall.RawSyscall({{ .Seccomp.Syscall }}, 1, 1, uintptr(goscriptunsafe.Pointer(&prog))); errno != 0 {
+		goscriptlog.Fatalln("goscript: seccomp:", errno)
+	}
+}
+{{- end }}
+
+type goscriptRegistration struct {
+	Name string
+	Type string
+	Ptr  bool
+}
+
+type goscriptRequest struct {
+	Register  []goscriptRegistration
+	{{- if .JSON }}
+	Args      []goscriptjson.RawMessage
+	{{- else }}
+	Args      []interface{}
+	{{- end }}
+	Raw       bool
+	RequestID string
+	Stream    bool
+	More      bool
+	Typed     bool
+	Deadline  goscripttime.Time
+	Func      string
+	Ping      bool
+	Yield     bool
+	Ack       bool
+	{{- if .JSON }}
+	Batch     [][]goscriptjson.RawMessage
+	{{- else }}
+	Batch     [][]interface{}
+	{{- end }}
+	Named     bool
+}
+
+type goscriptResponse struct {
+	Value      interface{}
+	Error      error
+	ErrorText  string
+	Err        string
+	Raw        []byte
+	Progress   int
+	IsProgress bool
+	Panicked   bool
+	Panic      string
+	Stack      string
+	Chunk      []byte
+	IsChunk    bool
+	Goroutines int
+	Output     []byte
+	IsOutput   bool
+	MultiValue bool
+	IsYield    bool
+	Batch      []goscriptResponse
 }
 `
@@ -1,8 +1,20 @@
 package goscript
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/matryer/is"
 )
@@ -78,35 +90,1865 @@ func goscript(separator string, items) (string, error) {
 		InArgs: []interface{}{"|", "one", "two", "three"},
 		OutErr: "goscript:4: syntax error: mixed named and unnamed function parameters",
 	},
+	{
+		Goscript: `
+// func goscript(a, b int) (int, error) isn't the signature
+func goscript(_ string, n int) (int, error) {
+	return n * 2, nil
+}
+`,
+		InArgs:   []interface{}{"ignored", 21},
+		OutValue: 42,
+	},
+}
+
+func TestWorkDir(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	is.NoErr(os.WriteFile(filepath.Join(dir, "data.csv"), []byte("a,b,c\n"), 0600))
+	for _, mode := range []ExecMode{GoRun, GoBuild} {
+		script := New(`
+import "os"
+
+func goscript(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	return string(b), err
+}
+`, WithWorkDir(dir), WithExecMode(mode))
+		defer script.Close()
+		v, err := script.Execute("./data.csv")
+		is.NoErr(err) // Execute
+		is.Equal(v, "a,b,c\n")
+	}
+}
+
+func TestCompileErrorLine(t *testing.T) {
+	is := is.New(t)
+	src := `import (
+	"os/exec"
+	"strings"
+)
+
+func goscript() (string, error) {
+	out, err := exec.Command("echo", "hi").Output()
+	return strings.TrimSpace(string(out)) + missing(), err
+}
+`
+	// the script's own imports come before the error, and the
+	// script is rewritten when commands are restricted
+	for _, opts := range [][]Option{nil, {WithAllowedCommands("echo")}} {
+		_, err := NewScript(src, opts...)
+		is.True(err != nil)
+		is.True(strings.HasPrefix(err.Error(), "goscript:8:")) // line of the script
+		is.True(strings.Contains(err.Error(), "undefined: missing"))
+	}
+}
+
+func TestHarnessNames(t *testing.T) {
+	is := is.New(t)
+	// the harness's own declarations and imports are namespaced,
+	// so scripts can use the same names
+	script := New(`
+import (
+	"encoding/gob"
+	"log"
+	"os"
+)
+
+type request struct {
+	Name string
+}
+
+type response struct {
+	Greeting string
+}
+
+var r, w = "r", "w"
+
+func goscript(name string) (string, error) {
+	log.SetOutput(os.Stderr)
+	_ = gob.NewEncoder(os.Stderr)
+	res := response{Greeting: "Hello " + request{Name: name}.Name}
+	return res.Greeting + r + w, nil
+}
+`)
+	defer script.Close()
+	v, err := script.Execute("Mat")
+	is.NoErr(err) // Execute
+	is.Equal(v, "Hello Matrw")
+
+	// as are its locals, so parameters can too
+	for _, codec := range []Codec{GobCodec, JSONCodec} {
+		script := New(`
+import (
+	"fmt"
+	"strings"
+)
+
+func goscript(req string, res int, args ...string) (string, error) {
+	return fmt.Sprintf("%s %d %s", req, res, strings.Join(args, ",")), nil
+}
+
+func goscript_sum(r string, i ...int) (string, error) {
+	return fmt.Sprint(r, i), nil
+}
+`, WithCodec(codec))
+		defer script.Close()
+		v, err := script.Execute("req", 1, "a", "b")
+		is.NoErr(err) // Execute
+		is.Equal(v, "req 1 a,b")
+		v, err = script.Call("sum", "r", 1, 2)
+		is.NoErr(err) // Call
+		is.Equal(v, "r[1 2]")
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	is := is.New(t)
+	src := `
+func goscript(name string) (string, error) {
+	return "Hello " + name, nil
+}
+`
+	script, err := NewReader(strings.NewReader(src))
+	is.NoErr(err) // NewReader
+	defer script.Close()
+	v, err := script.Execute("Mat")
+	is.NoErr(err) // Execute
+	is.Equal(v, "Hello Mat")
+
+	path := filepath.Join(t.TempDir(), "hello.goscript")
+	is.NoErr(os.WriteFile(path, []byte(src), 0600))
+	script, err = NewFile(path)
+	is.NoErr(err) // NewFile
+	defer script.Close()
+	v, err = script.Execute("Mat")
+	is.NoErr(err) // Execute
+	is.Equal(v, "Hello Mat")
+
+	_, err = NewFile(filepath.Join(t.TempDir(), "missing.goscript"))
+	is.True(errors.Is(err, os.ErrNotExist)) // missing file
+}
+
+func TestNewLazy(t *testing.T) {
+	is := is.New(t)
+	script := NewLazy(`
+func goscript(name string) (string, error) {
+	return "Hello " + name, nil
+}
+`)
+	defer script.Close()
+	is.True(!script.Healthy())      // not started yet
+	is.Equal(script.Source(), "")   // not compiled yet
+	v, err := script.Execute("Mat") // starts the script
+	is.NoErr(err)                   // Execute
+	is.Equal(v, "Hello Mat")
+	is.True(script.Healthy())
+	is.NoErr(script.Start()) // already started
+
+	script = NewLazy(`func goscript() (int, error) { return "nope", nil }`)
+	defer script.Close()
+	err = script.Start()
+	is.True(err != nil) // compile error
+	_, err2 := script.Execute()
+	is.Equal(err2, err) // calls return the compile error
+
+	script = NewLazy(`func goscript() (int, error) { return 1, nil }`)
+	is.NoErr(script.Close())
+	_, err = script.Execute()
+	is.True(err != nil) // closed before starting
+	is.True(strings.Contains(err.Error(), "closed"))
+}
+
+func TestErrorOnly(t *testing.T) {
+	for _, codec := range []Codec{GobCodec, JSONCodec} {
+		is := is.New(t)
+		script := New(`
+import "errors"
+
+var calls int
+
+func goscript() error {
+	calls++
+	if calls > 1 {
+		return errors.New("called again")
+	}
+	return nil
+}
+
+func goscript_check(name string) error {
+	if name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+func goscript_nothing() (interface{}, error) {
+	return nil, nil
+}
+`, WithCodec(codec))
+		defer script.Close()
+		v, err := script.Execute()
+		is.NoErr(err) // Execute
+		is.Equal(v, nil)
+		v, err = script.Execute()
+		is.Equal(err.Error(), "called again")
+		is.Equal(v, nil)
+		v, err = script.Call("check", "Mat")
+		is.NoErr(err) // Call
+		is.Equal(v, nil)
+		_, err = script.Call("check", "")
+		is.Equal(err.Error(), "name is required")
+		v, err = script.Call("nothing")
+		is.NoErr(err) // nil value
+		is.Equal(v, nil)
+	}
+}
+
+func TestErrorOnlySideEffects(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import "os"
+
+func goscript(path string) error {
+	return os.WriteFile(path, []byte("done"), 0600)
+}
+`)
+	defer script.Close()
+	path := filepath.Join(t.TempDir(), "out.txt")
+	v, err := script.Execute(path)
+	is.NoErr(err) // Execute
+	is.Equal(v, nil)
+	b, err := os.ReadFile(path)
+	is.NoErr(err) // file written by the script
+	is.Equal(string(b), "done")
+	_, err = script.Execute(filepath.Join(t.TempDir(), "missing", "out.txt"))
+	is.True(err != nil) // error returned
+}
+
+func TestGoscriptTests(t *testing.T) {
+	is := is.New(t)
+	for i := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			is := is.New(t)
+			test := tests[i]
+			script := New(test.Goscript)
+			defer script.Close()
+			val, err := script.Execute(test.InArgs...)
+			if err != nil {
+				is.Equal(err.Error(), test.OutErr)
+			} else {
+				if test.OutErr != "" {
+					is.Fail() // OutErr specified but error was nil
+				}
+			}
+			if test.OutValue != nil {
+				is.Equal(val, test.OutValue)
+			}
+		})
+	}
+}
+
+func TestStdoutResult(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import (
+	"fmt"
+	"strings"
+)
+
+func goscript(s string) error {
+	fmt.Print(strings.ToUpper(s))
+	return nil
+}
+`, WithStdoutResult())
+	defer script.Close()
+	out, err := script.Execute("hello")
+	is.NoErr(err) // Execute
+	is.Equal(out, "HELLO")
+	out, err = script.Execute("again")
+	is.NoErr(err) // Execute
+	is.Equal(out, "AGAIN")
+}
+
+func TestReload(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript() (string, error) {
+	return "v1", nil
+}
+`)
+	defer script.Close()
+	v, err := script.Execute()
+	is.NoErr(err) // Execute
+	is.Equal(v, "v1")
+
+	var wg sync.WaitGroup
+	results := make(chan interface{}, 100)
+	errs := make(chan error, 100)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				v, err := script.Execute()
+				if err != nil {
+					errs <- err
+					continue
+				}
+				results <- v
+			}
+		}()
+	}
+	err = script.Reload(`
+func goscript() (string, error) {
+	return "v2", nil
+}
+`)
+	is.NoErr(err) // Reload
+	wg.Wait()
+	close(results)
+	close(errs)
+	for err := range errs {
+		is.NoErr(err) // concurrent Execute
+	}
+	n := 0
+	for v := range results {
+		is.True(v == "v1" || v == "v2")
+		n++
+	}
+	is.Equal(n, 100)
+
+	v, err = script.Execute()
+	is.NoErr(err) // Execute
+	is.Equal(v, "v2")
+}
+
+func TestReturnedErrors(t *testing.T) {
+	for _, codec := range []Codec{GobCodec, JSONCodec} {
+		is := is.New(t)
+		script := New(`
+import (
+	"errors"
+	"fmt"
+)
+
+var errNotFound = errors.New("not found")
+
+func goscript(name string) (string, error) {
+	switch name {
+	case "":
+		return "", errors.New("name is required")
+	case "nobody":
+		return "", fmt.Errorf("finding %s: %w", name, errNotFound)
+	}
+	return "Hello " + name, nil
+}
+`, WithCodec(codec))
+		defer script.Close()
+		_, err := script.Execute("")
+		is.True(err != nil) // errors.New
+		is.Equal(err.Error(), "name is required")
+		_, err = script.Execute("nobody")
+		is.True(err != nil) // fmt.Errorf
+		is.Equal(err.Error(), "finding nobody: not found")
+		v, err := script.Execute("Mat")
+		is.NoErr(err) // Execute after errors
+		is.Equal(v, "Hello Mat")
+	}
+}
+
+func TestErrorExitCode(t *testing.T) {
+	for _, mode := range []ExecMode{GoRun, GoBuild} {
+		is := is.New(t)
+		script := New(`
+import "syscall"
+
+func goscript() (string, error) {
+	syscall.Write(2, []byte("giving up\n"))
+	syscall.Exit(3)
+	return "", nil
+}
+`, WithExecMode(mode))
+		defer script.Close()
+		_, err := script.Execute()
+		var gerr Error
+		is.True(errors.As(err, &gerr))
+		is.Equal(gerr.ExitCode, 3)
+		var exitErr *exec.ExitError
+		is.True(errors.As(err, &exitErr)) // Unwrap
+		is.Equal(gerr.Stderr, "giving up")
+		is.Equal(err.Error(), "giving up\ngoscript: the script exited with code 3")
+	}
+}
+
+func TestClose(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import "os"
+
+func goscript(code int) (string, error) {
+	if code > 0 {
+		os.Exit(code)
+	}
+	return "ok", nil
+}
+`, WithExecMode(GoBuild))
+	_, err := script.Execute(0)
+	is.NoErr(err) // Execute
+	is.NoErr(script.Close())
+	is.NoErr(script.Close()) // again
+
+	script = New(`
+import "os"
+
+func goscript(code int) (string, error) {
+	os.Exit(code)
+	return "", nil
+}
+`, WithExecMode(GoBuild))
+	_, err = script.Execute(4)
+	is.True(err != nil)
+	err = script.Close()
+	var gerr Error
+	is.True(errors.As(err, &gerr))
+	is.Equal(gerr.ExitCode, 4)
+	is.Equal(script.Close(), err) // again
+}
+
+func TestCloseRemovesTempDirs(t *testing.T) {
+	is := is.New(t)
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	for _, mode := range []ExecMode{GoRun, GoBuild} {
+		for i := 0; i < 5; i++ {
+			script := New(`
+func goscript() (string, error) {
+	return "ok", nil
+}
+`, WithExecMode(mode))
+			_, err := script.Execute()
+			is.NoErr(err) // Execute
+			is.NoErr(script.Close())
+		}
+		_, err := NewScript(`
+func goscript() (string, error) {
+	return missing, nil
+}
+`, WithExecMode(mode))
+		is.True(err != nil) // doesn't compile
+	}
+	entries, err := os.ReadDir(tmp)
+	is.NoErr(err) // ReadDir
+	for _, entry := range entries {
+		is.True(!strings.HasPrefix(entry.Name(), "goscript")) // left behind
+	}
+}
+
+func TestOnCrash(t *testing.T) {
+	is := is.New(t)
+	type crash struct {
+		err    error
+		stderr string
+	}
+	crashes := make(chan crash, 1)
+	script := New(`
+import "syscall"
+
+func goscript() (int, error) {
+	return syscall.Getpid(), nil
+}
+`, OnCrash(func(err error, stderr string) {
+		crashes <- crash{err: err, stderr: stderr}
+	}))
+	defer script.Close()
+	pid, err := script.Execute()
+	is.NoErr(err) // Execute
+	proc, err := os.FindProcess(pid.(int))
+	is.NoErr(err) // FindProcess
+	is.NoErr(proc.Kill())
+	select {
+	case c := <-crashes:
+		var exitErr *exec.ExitError
+		is.True(errors.As(c.err, &exitErr))
+		is.True(exitErr.ExitCode() != 0)
+	case <-time.After(5 * time.Second):
+		is.Fail() // OnCrash not called
+	}
+	_, err = script.Execute()
+	is.True(err != nil) // Execute after crash
+}
+
+type autoRegPerson struct {
+	Name string
+}
+
+func TestAutoRegister(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import "strings"
+
+type autoRegPerson struct {
+	Name string
+}
+
+func goscript(p autoRegPerson) (autoRegPerson, error) {
+	p.Name = strings.ToUpper(p.Name)
+	return p, nil
+}
+`, WithAutoRegister())
+	defer script.Close()
+	p, err := script.Execute(autoRegPerson{Name: "mat"})
+	is.NoErr(err) // Execute
+	is.Equal(p, autoRegPerson{Name: "MAT"})
+	p, err = script.Execute(autoRegPerson{Name: "david"})
+	is.NoErr(err) // Execute
+	is.Equal(p, autoRegPerson{Name: "DAVID"})
+}
+
+func TestProgress(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import "time"
+
+func goscript(setProgress func(int), steps int) (int, error) {
+	for i := 1; i <= steps; i++ {
+		time.Sleep(100 * time.Millisecond)
+		setProgress(i * 100 / steps)
+	}
+	return steps, nil
+}
+`)
+	defer script.Close()
+	done := make(chan error)
+	go func() {
+		_, err := script.Execute(5)
+		done <- err
+	}()
+	var seen bool
+	for !seen {
+		select {
+		case err := <-done:
+			is.NoErr(err)
+			t.Fatal("finished without reporting progress mid-flight")
+		case <-time.After(10 * time.Millisecond):
+			p := script.Progress()
+			seen = p > 0 && p < 100
+		}
+	}
+	is.NoErr(<-done) // Execute
+	is.Equal(script.Progress(), 100)
+}
+
+func TestWrapperConflicts(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func main() {}
+
+func goscript() (string, error) {
+	return "", nil
+}
+`)
+	defer script.Close()
+	_, err := script.Execute()
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "must not declare func main"))
+
+	script = New(`
+package main
+
+func goscript() (string, error) {
+	return "", nil
+}
+`)
+	defer script.Close()
+	_, err = script.Execute()
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "must not have a package clause"))
+
+	for _, reserved := range []struct {
+		name, decl string
+	}{
+		{"main", "var main = 1"},
+		{"goscriptCall", "type goscriptCall struct{}"},
+		{"goscriptWriteMarker", "func goscriptWriteMarker() {}"},
+	} {
+		script = New(`
+import "strings"
+
+` + reserved.decl + `
+
+func goscript() (string, error) {
+	return strings.ToUpper("ok"), nil
+}
+`)
+		defer script.Close()
+		_, err = script.Execute()
+		is.True(err != nil) // reserved name
+		is.Equal(err.Error(), fmt.Sprintf("goscript:4: %q is reserved by goscript", reserved.name))
+	}
+
+	for _, reserved := range []struct {
+		name, fn string
+	}{
+		{"goscriptOK", "func goscript(goscriptOK string) (string, error)"},
+		{"goscriptArgErr", "func goscript(name, goscriptArgErr string) (string, error)"},
+		{"goscriptRes", "func goscript_greet(goscriptRes string) (string, error)"},
+		{"goscript", "func goscript_greet(goscript string) (string, error)"},
+	} {
+		script = New(`
+` + reserved.fn + ` {
+	return "ok", nil
+}
+`)
+		defer script.Close()
+		_, err = script.Execute("a", "b")
+		is.True(err != nil) // reserved parameter name
+		is.Equal(err.Error(), fmt.Sprintf("goscript:2: %q is reserved by goscript", reserved.name))
+	}
+}
+
+func TestExecuteTo(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript(salutation, name string) (string, error) {
+	return salutation + " " + name, nil
+}
+`)
+	defer script.Close()
+	var buf bytes.Buffer
+	err := script.ExecuteTo(&buf, "Hello", "Mat")
+	is.NoErr(err) // ExecuteTo
+	greeting, err := DecodeResult(&buf)
+	is.NoErr(err) // DecodeResult
+	is.Equal(greeting, "Hello Mat")
+}
+
+type intoPerson struct {
+	Name string
+	Age  int
+}
+
+func TestExecuteInto(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+type person struct {
+	Name string
+	Age  int
+}
+
+func goscript(name string, age int) (interface{}, error) {
+	if age < 0 {
+		return name, nil
+	}
+	return person{Name: name, Age: age}, nil
+}
+`)
+	defer script.Close()
+	var p intoPerson
+	err := script.ExecuteInto(&p, "Mat", 40)
+	is.NoErr(err) // ExecuteInto struct
+	is.Equal(p, intoPerson{Name: "Mat", Age: 40})
+	var name string
+	err = script.ExecuteInto(&name, "Mat", -1)
+	is.NoErr(err) // ExecuteInto string
+	is.Equal(name, "Mat")
+	err = script.ExecuteInto(name, "Mat", -1)
+	is.Equal(err.Error(), "goscript: ExecuteInto needs a non-nil pointer")
+	var nilp *string
+	err = script.ExecuteInto(nilp, "Mat", -1)
+	is.Equal(err.Error(), "goscript: ExecuteInto needs a non-nil pointer")
+}
+
+func TestArgCoercion(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript(n int, double bool, scale ...float64) (float64, error) {
+	v := float64(n)
+	if double {
+		v *= 2
+	}
+	for _, s := range scale {
+		v *= s
+	}
+	return v, nil
+}
+`, WithArgCoercion())
+	defer script.Close()
+	v, err := script.Execute("42", "true", "0.5", 2.0)
+	is.NoErr(err) // Execute
+	is.Equal(v, 84.0)
+	_, err = script.Execute("forty-two", "true")
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "argument n"))
+}
+
+type strictPoint struct {
+	X, Y int
+}
+
+func TestStrictArgs(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+type strictPoint struct {
+	X, Y int
+}
+
+func goscript(n int, p *strictPoint, names ...string) (int, error) {
+	return n + p.X + p.Y + len(names), nil
+}
+`, WithStrictArgs(), WithAutoRegister())
+	defer script.Close()
+	v, err := script.Execute(1, &strictPoint{X: 2, Y: 3}, "a", "b")
+	is.NoErr(err) // Execute
+	is.Equal(v, 8)
+	_, err = script.Execute(int32(1), &strictPoint{})
+	is.True(err != nil)
+	is.Equal(err.Error(), "goscript: argument n: got int32, want int")
+	_, err = script.Execute(1, strictPoint{})
+	is.True(err != nil)
+	is.Equal(err.Error(), "goscript: argument p: got goscript.strictPoint, want *strictPoint")
+	_, err = script.Execute(1, &strictPoint{}, "a", 2)
+	is.True(err != nil)
+	is.Equal(err.Error(), "goscript: argument names: got int, want string")
+	_, err = script.Execute(1)
+	is.True(err != nil)
+	is.Equal(err.Error(), "goscript: got 1 arguments, want at least 2 (int, *strictPoint, ...string)")
+}
+
+func TestArgMismatch(t *testing.T) {
+	for _, codec := range []Codec{GobCodec, JSONCodec} {
+		is := is.New(t)
+		script := New(`
+func goscript(name string, n int) (string, error) {
+	return name, nil
+}
+
+func goscript_sum(nums ...int) (int, error) {
+	return len(nums), nil
+}
+`, WithCodec(codec))
+		defer script.Close()
+		_, err := script.Execute("mat")
+		is.True(err != nil)
+		is.Equal(err.Error(), "goscript: got 1 arguments, want 2 (string, int)")
+		_, err = script.Execute("mat", 1, 2)
+		is.True(err != nil)
+		is.Equal(err.Error(), "goscript: got 3 arguments, want 2 (string, int)")
+		_, err = script.Execute("mat", "1")
+		is.True(err != nil)
+		is.True(strings.HasPrefix(err.Error(), "goscript: argument n: "))
+		_, err = script.Call("sum", 1, "2")
+		is.True(err != nil)
+		is.True(strings.HasPrefix(err.Error(), "goscript: argument nums: "))
+		v, err := script.Execute("mat", 1)
+		is.NoErr(err) // Execute after bad calls
+		is.Equal(v, "mat")
+	}
+}
+
+func TestLongVariadic(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript(scale int, nums ...int) (int, error) {
+	sum := 0
+	for _, n := range nums {
+		sum += n
+	}
+	return sum * scale, nil
+}
+`)
+	defer script.Close()
+	args := []interface{}{2}
+	for i := 1; i <= 100000; i++ {
+		args = append(args, i)
+	}
+	sum, err := script.Execute(args...)
+	is.NoErr(err) // Execute
+	is.Equal(sum, 2*100000*100001/2)
+}
+
+// requestRecorder records the requests sent to a process.
+type requestRecorder []request
+
+func (r *requestRecorder) Encode(v interface{}) error {
+	*r = append(*r, v.(request))
+	return nil
+}
+
+func TestSendChunksVariadicArgs(t *testing.T) {
+	is := is.New(t)
+	var rec requestRecorder
+	p := &process{
+		params:       scriptParams(t, "func goscript(scale int, nums ...int) (int, error) { return 0, nil }"),
+		stdinencoder: &rec,
+	}
+	args := []interface{}{2}
+	for i := 0; i < 2*argChunkSize+1; i++ {
+		args = append(args, i)
+	}
+	is.NoErr(p.send(request{Args: args}))
+	is.Equal(len(rec), 3)
+	var sent []interface{}
+	for i, req := range rec {
+		is.True(len(req.Args) <= argChunkSize+1) // frame too big
+		is.Equal(req.More, i < len(rec)-1)
+		sent = append(sent, req.Args...)
+	}
+	is.Equal(sent, args)
+}
+
+func TestRequestID(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript(name string) (string, error) {
+	return RequestID() + ": " + name, nil
+}
+`)
+	defer script.Close()
+	ctx := ContextWithRequestID(context.Background(), "req-123")
+	v, err := script.ExecuteContext(ctx, "Mat")
+	is.NoErr(err) // ExecuteContext
+	is.Equal(v, "req-123: Mat")
+	v, err = script.Execute("Mat")
+	is.NoErr(err) // Execute
+	is.Equal(v, ": Mat")
+}
+
+func TestRequestIDUnused(t *testing.T) {
+	is := is.New(t)
+	for _, src := range []string{
+		`
+// goscript doesn't call RequestID() here.
+func goscript(name string) (string, error) {
+	return name, nil
+}
+`, `
+func goscript(name string) (string, error) {
+	return "RequestID()" + name, nil
+}
+`, `
+type call struct{ id string }
+
+func (c call) RequestID() string { return c.id }
+
+func goscript(name string) (string, error) {
+	return call{name}.RequestID(), nil
+}
+`, `
+func RequestID() string { return "mine" }
+
+func goscript(name string) (string, error) {
+	return RequestID() + name, nil
+}
+`,
+	} {
+		generated, err := GenerateSource(src)
+		is.NoErr(err) // GenerateSource
+		is.True(!strings.Contains(generated, "goscriptRequestID"))
+		script := New(src)
+		_, err = script.Execute("Mat")
+		script.Close()
+		is.NoErr(err) // Execute
+	}
+	generated, err := GenerateSource(`
+func goscript(name string) (string, error) {
+	return RequestID() + name, nil
+}
+`)
+	is.NoErr(err) // GenerateSource
+	is.True(strings.Contains(generated, "goscriptRequestID"))
+}
+
+func TestDeadline(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import "context"
+
+// goscript counts up to n, unless the context is done first.
+func goscript(ctx context.Context, n int) (int, error) {
+	i := 0
+	for ; i < n; i++ {
+		select {
+		case <-ctx.Done():
+			return i, nil
+		default:
+		}
+	}
+	return i, nil
+}
+`)
+	defer script.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	n, err := script.ExecuteContext(ctx, math.MaxInt64)
+	is.NoErr(err)                    // ExecuteContext
+	is.True(n.(int) < math.MaxInt64) // returned early
+	n, err = script.Execute(1000)
+	is.NoErr(err) // Execute
+	is.Equal(n, 1000)
+
+	script = New(`
+import "time"
+
+func goscript(deadline time.Time, name string) (string, error) {
+	if deadline.IsZero() {
+		return "no deadline for " + name, nil
+	}
+	return time.Until(deadline).Round(time.Hour).String() + " for " + name, nil
+}
+`)
+	defer script.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	out, err := script.ExecuteContext(ctx, "Mat")
+	is.NoErr(err) // ExecuteContext
+	is.Equal(out, "1h0m0s for Mat")
+	out, err = script.Execute("Mat")
+	is.NoErr(err) // Execute
+	is.Equal(out, "no deadline for Mat")
+}
+
+func TestPanic(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript(n int) (int, error) {
+	if n < 0 {
+		panic("negative")
+	}
+	return n, nil
+}
+`)
+	defer script.Close()
+	_, err := script.Execute(-1)
+	var perr PanicError
+	is.True(errors.As(err, &perr))
+	is.Equal(perr.Value, "negative")
+	is.True(strings.Contains(perr.Stack, "goscript:4")) // stack references the panic line
+	n, err := script.Execute(1)
+	is.NoErr(err) // Execute after panic
+	is.Equal(n, 1)
+}
+
+func TestExecuteMulti(t *testing.T) {
+	for _, codec := range []Codec{GobCodec, JSONCodec} {
+		is := is.New(t)
+		script := New(`
+import "strings"
+
+func goscript(s string) (int, string, bool, error) {
+	return len(s), strings.ToUpper(s), s == "", nil
+}
+`, WithCodec(codec))
+		defer script.Close()
+		values, err := script.ExecuteMulti("mat")
+		is.NoErr(err) // ExecuteMulti
+		is.Equal(len(values), 3)
+		is.Equal(fmt.Sprint(values[0]), "3")
+		is.Equal(values[1], "MAT")
+		is.Equal(values[2], false)
+		v, err := script.Execute("mat")
+		is.NoErr(err) // Execute
+		is.Equal(len(v.([]interface{})), 3)
+	}
+
+	is := is.New(t)
+	script := New(`
+func goscript(s string) (string, error) {
+	return s, nil
+}
+`)
+	defer script.Close()
+	values, err := script.ExecuteMulti("mat")
+	is.NoErr(err) // ExecuteMulti with one value
+	is.Equal(values, []interface{}{"mat"})
+}
+
+func TestPanicCall(t *testing.T) {
+	for _, codec := range []Codec{GobCodec, JSONCodec} {
+		is := is.New(t)
+		script := New(`
+func goscript_div(a, b int) (int, error) {
+	return a / b, nil
+}
+`, WithCodec(codec))
+		defer script.Close()
+		_, err := script.Call("div", 1, 0)
+		var perr PanicError
+		is.True(errors.As(err, &perr))
+		is.Equal(perr.Value, "runtime error: integer divide by zero")
+		is.True(strings.Contains(perr.Stack, "goscript:3")) // stack references the panic line
+		v, err := script.Call("div", 6, 3)
+		is.NoErr(err) // Call after panic
+		// JSON decodes numbers as float64
+		is.Equal(fmt.Sprint(v), "2")
+	}
+}
+
+func TestMaxExecutions(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+var calls int
+
+func goscript() (int, error) {
+	calls++
+	return calls, nil
+}
+`, WithMaxExecutions(3))
+	defer script.Close()
+	for _, expected := range []int{1, 2, 3, 1, 2, 3, 1} {
+		n, err := script.Execute()
+		is.NoErr(err) // Execute
+		is.Equal(n, expected)
+	}
+}
+
+func TestExecuteRetries(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import (
+	"io/ioutil"
+	"syscall"
+)
+
+// goscript crashes the first time it is called with each marker file.
+func goscript(marker string) (string, error) {
+	if _, err := ioutil.ReadFile(marker); err != nil {
+		if err := ioutil.WriteFile(marker, nil, 0600); err != nil {
+			return "", err
+		}
+		syscall.Exit(1)
+	}
+	return "ok", nil
+}
+`, WithExecuteRetries(2))
+	defer script.Close()
+	dir := t.TempDir()
+	ctx := ContextWithIdempotent(context.Background())
+	out, err := script.ExecuteContext(ctx, filepath.Join(dir, "idempotent"))
+	is.NoErr(err) // retried
+	is.Equal(out, "ok")
+	_, err = script.Execute(filepath.Join(dir, "once"))
+	is.True(err != nil) // not idempotent, so not retried
+}
+
+func TestExtraFiles(t *testing.T) {
+	is := is.New(t)
+	r, w, err := os.Pipe()
+	is.NoErr(err) // Pipe
+	defer r.Close()
+	_, err = w.Write([]byte("from the host"))
+	is.NoErr(err) // Write
+	is.NoErr(w.Close())
+	script := New(`
+import "syscall"
+
+func goscript() (string, error) {
+	var content []byte
+	buf := make([]byte, 64)
+	for {
+		n, err := syscall.Read(3, buf)
+		if err != nil {
+			return "", err
+		}
+		if n == 0 {
+			return string(content), nil
+		}
+		content = append(content, buf[:n]...)
+	}
+}
+`, WithExtraFiles(r))
+	defer script.Close()
+	content, err := script.Execute()
+	is.NoErr(err) // Execute
+	is.Equal(content, "from the host")
+}
+
+type circle struct {
+	Radius float64
+}
+
+type square struct {
+	Side float64
+}
+
+func TestRegisterTypeAs(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+type circle struct {
+	Radius float64
+}
+
+type square struct {
+	Side float64
+}
+
+func goscript(shape interface{}) (interface{}, error) {
+	switch shape := shape.(type) {
+	case circle:
+		return square{Side: shape.Radius * 2}, nil
+	case square:
+		return circle{Radius: shape.Side / 2}, nil
+	}
+	return nil, nil
+}
+`)
+	defer script.Close()
+	is.NoErr(script.RegisterTypeAs("shapes.Circle", circle{}))
+	is.NoErr(script.RegisterTypeAs("shapes.Square", square{}))
+	v, err := script.Execute(circle{Radius: 2})
+	is.NoErr(err) // Execute circle
+	is.Equal(v, square{Side: 4})
+	v, err = script.Execute(square{Side: 4})
+	is.NoErr(err) // Execute square
+	is.Equal(v, circle{Radius: 2})
+}
+
+type contact struct {
+	Name  string
+	Email string
+}
+
+func TestRegister(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+type contact struct {
+	Name  string
+	Email string
+}
+
+func goscript(name string) (interface{}, error) {
+	return contact{Name: name, Email: name + "@example.com"}, nil
+}
+`)
+	defer script.Close()
+	is.NoErr(script.Register(contact{}))
+	v, err := script.Execute("mat")
+	is.NoErr(err) // Execute
+	is.Equal(v, contact{Name: "mat", Email: "mat@example.com"})
+	is.True(script.Register(42) != nil)
+}
+
+func TestCommonTypes(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript(v interface{}) (interface{}, error) {
+	return v, nil
+}
+`)
+	defer script.Close()
+	for _, v := range []interface{}{
+		[]byte{0, 1, 2, 255},
+		map[string]int{"one": 1, "two": 2},
+		[][]string{{"a", "b"}, {"c"}},
+		map[string]interface{}{
+			"n":      1,
+			"s":      "x",
+			"data":   []byte("binary\x00data"),
+			"nested": []interface{}{1.5, "two", map[string]string{"k": "v"}},
+		},
+	} {
+		got, err := script.Execute(v)
+		is.NoErr(err) // Execute
+		is.Equal(got, v)
+	}
+
+	script = New(`
+func goscript(counts map[string]int, key string) (map[string]int, error) {
+	counts[key]++
+	return counts, nil
+}
+`)
+	defer script.Close()
+	got, err := script.Execute(map[string]int{"a": 1}, "a")
+	is.NoErr(err) // Execute
+	is.Equal(got, map[string]int{"a": 2})
+}
+
+func TestSetOutput(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import "fmt"
+
+func goscript(name string) (string, error) {
+	fmt.Println("greeting", name)
+	return "Hello " + name, nil
+}
+`)
+	defer script.Close()
+	var out lockedBuffer
+	script.SetOutput(&out)
+	v, err := script.Execute("mat")
+	is.NoErr(err) // Execute
+	is.Equal(v, "Hello mat")
+	deadline := time.Now().Add(5 * time.Second)
+	for out.String() == "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	is.Equal(out.String(), "greeting mat\n")
 }
 
-func TestGoscriptTests(t *testing.T) {
+func TestStderr(t *testing.T) {
 	is := is.New(t)
-	for i := range tests {
-		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
-			is := is.New(t)
-			test := tests[i]
-			script := New(test.Goscript)
-			defer script.Close()
-			val, err := script.Execute(test.InArgs...)
-			if err != nil {
-				is.Equal(err.Error(), test.OutErr)
-			} else {
-				if test.OutErr != "" {
-					is.Fail() // OutErr specified but error was nil
-				}
-			}
-			if test.OutValue != nil {
-				is.Equal(val, test.OutValue)
+	script := New(`
+import (
+	"fmt"
+	"os"
+)
+
+func goscript(name string) (string, error) {
+	fmt.Fprintln(os.Stderr, "warning: greeting", name)
+	return "Hello " + name, nil
+}
+`)
+	defer script.Close()
+	is.Equal(script.Stderr(), "") // nothing written yet
+	v, err := script.Execute("mat")
+	is.NoErr(err) // Execute
+	is.Equal(v, "Hello mat")
+	deadline := time.Now().Add(5 * time.Second)
+	for script.Stderr() == "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	is.Equal(script.Stderr(), "warning: greeting mat")
+}
+
+func TestStderrHeavy(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func goscript(mb int) (string, error) {
+	line := strings.Repeat("x", 1023)
+	for i := 0; i < mb*1024; i++ {
+		fmt.Fprintln(os.Stderr, line)
+	}
+	return "done", nil
+}
+`)
+	defer script.Close()
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 3; i++ {
+			if _, err := script.Execute(4); err != nil {
+				done <- err
+				return
 			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		is.NoErr(err) // Execute
+	case <-time.After(30 * time.Second):
+		t.Fatal("Execute blocked with the script writing to stderr")
+	}
+	is.True(len(script.Stderr()) <= maxStderr) // only the end is kept
+}
+
+func TestStrayOutputBeforeReady(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import "fmt"
+
+var _ = fmt.Sprint(fmt.Print("noise from init"))
+
+func goscript() (string, error) {
+	return "ok", nil
+}
+`)
+	defer script.Close()
+	_, err := script.Execute()
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "unexpected output before the script was ready"))
+	is.True(strings.Contains(err.Error(), "noise from init"))
+}
+
+func TestErrorFormatter(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript() (string, error) {
+	return undefinedName, nil
+}
+`, WithErrorFormatter(func(e Error) string {
+		b, err := json.Marshal(map[string]string{
+			"error":  e.Err.Error(),
+			"output": e.Error(),
 		})
+		if err != nil {
+			return err.Error()
+		}
+		return string(b)
+	}))
+	defer script.Close()
+	_, err := script.Execute()
+	is.True(err != nil)
+	var e map[string]string
+	is.NoErr(json.Unmarshal([]byte(err.Error()), &e)) // error is JSON
+	is.Equal(e["error"], "exit status 1")
+	is.Equal(e["output"], "goscript:3:9: undefined: undefinedName")
+}
+
+func TestExtraFile(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript(name string) (string, error) {
+	return greet(name), nil
+}
+`, WithExtraFile("helpers.go", `package main
+
+import "strings"
+
+func greet(name string) string {
+	return "Hello " + strings.ToUpper(name)
+}
+`))
+	defer script.Close()
+	greeting, err := script.Execute("Mat")
+	is.NoErr(err) // Execute
+	is.Equal(greeting, "Hello MAT")
+
+	for _, tc := range []struct{ name, src, err string }{
+		{"goscript.go", "package main", `duplicate source file "goscript.go"`},
+		{"../helpers.go", "package main", `invalid source file name "../helpers.go"`},
+		{"helpers_test.go", "package main", `invalid source file name "helpers_test.go"`},
+		{"helpers.go", "package helpers", "source file helpers.go must be package main"},
+	} {
+		script := New(`
+func goscript() (string, error) {
+	return "", nil
+}
+`, WithExtraFile(tc.name, tc.src))
+		_, err := script.Execute()
+		is.True(err != nil)
+		is.Equal(err.Error(), "goscript: "+tc.err)
+		script.Close()
+	}
+}
+
+func TestBuildTags(t *testing.T) {
+	is := is.New(t)
+	src := `
+func goscript() (string, error) {
+	return mode(), nil
+}
+`
+	tagged := WithExtraFile("tagged.go", `//go:build goscripttest
+
+package main
+
+func mode() string { return "tagged" }
+`)
+	untagged := WithExtraFile("untagged.go", `//go:build !goscripttest
+
+package main
+
+func mode() string { return "untagged" }
+`)
+	for _, mode := range []ExecMode{GoRun, GoBuild} {
+		script := New(src, tagged, untagged, WithExecMode(mode))
+		defer script.Close()
+		v, err := script.Execute()
+		is.NoErr(err) // Execute
+		is.Equal(v, "untagged")
+
+		script = New(src, tagged, untagged, WithExecMode(mode), WithBuildTags("goscripttest"))
+		defer script.Close()
+		v, err = script.Execute()
+		is.NoErr(err) // Execute with build tags
+		is.Equal(v, "tagged")
+	}
+
+	script := New(src, WithBuildTags("good", "not,good"))
+	defer script.Close()
+	_, err := script.Execute()
+	is.True(err != nil)
+	is.Equal(err.Error(), `goscript: invalid build tag "not,good"`)
+}
+
+func TestBuildFlags(t *testing.T) {
+	is := is.New(t)
+	src := `
+var version = "dev"
+
+func goscript() (string, error) {
+	return version, nil
+}
+`
+	for _, mode := range []ExecMode{GoRun, GoBuild} {
+		script := New(src, WithExecMode(mode), WithBuildFlags("-ldflags", "-X main.version=1.2.3", "-trimpath"))
+		defer script.Close()
+		v, err := script.Execute()
+		is.NoErr(err) // Execute
+		is.Equal(v, "1.2.3")
+	}
+
+	for _, tc := range []struct {
+		flags []string
+		err   string
+	}{
+		{[]string{"-o", "/tmp/binary"}, `build flag "-o" can't be used`},
+		{[]string{"--exec=true"}, `build flag "--exec=true" can't be used`},
+		{[]string{"race"}, `build flag "race" must start with -`},
+		{[]string{"-trimpath=true", "race"}, `build flag "race" must start with -`},
+		{[]string{"-gcflags", "-N\n-l"}, `invalid build flag "-N\n-l"`},
+	} {
+		script := New(src, WithBuildFlags(tc.flags...))
+		_, err := script.Execute()
+		is.True(err != nil)
+		is.Equal(err.Error(), "goscript: "+tc.err)
+		script.Close()
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript() (string, error) {
+	go func() {
+		panic("token=abc123 leaked")
+	}()
+	select {}
+}
+`, WithRedactor(RedactPatterns(regexp.MustCompile(`token=\S+`))))
+	defer script.Close()
+	_, err := script.Execute()
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "panic: [REDACTED] leaked"))
+	is.True(!strings.Contains(err.Error(), "abc123"))
+}
+
+func TestUserLineRange(t *testing.T) {
+	is := is.New(t)
+	src := `
+import "strings"
+
+func goscript(name string) (string, error) {
+	return "Hello " + strings.ToUpper(name), nil
+}
+`
+	script := New(src)
+	defer script.Close()
+	_, err := script.Execute("Mat")
+	is.NoErr(err) // Execute
+	start, end := script.UserLineRange()
+	lines := strings.Split(script.Source(), "\n")
+	is.Equal(lines[start-2], "//line goscript:1:1")
+	is.Equal(strings.Join(lines[start-1:end], "\n"), src)
+	is.Equal(lines[end], "// </goscript>")
+}
+
+func TestGenerateSource(t *testing.T) {
+	is := is.New(t)
+	src := `
+func goscript(name string) (string, error) {
+	return "Hello " + name, nil
+}
+`
+	script := New(src, WithCodec(JSONCodec))
+	defer script.Close()
+	generated, err := GenerateSource(src, WithCodec(JSONCodec))
+	is.NoErr(err) // GenerateSource
+	is.Equal(generated, script.Source())
+
+	broken := `
+func goscript(name string) (string, error) {
+	return 1, nil
+}
+`
+	_, err = NewScript(broken)
+	is.True(err != nil)
+	generated, err = GenerateSource(broken)
+	is.NoErr(err) // GenerateSource for a script that doesn't compile
+	is.True(strings.Contains(generated, broken))
+	is.True(strings.Contains(generated, "func main() {"))
+
+	_, err = GenerateSource("func helper() {}")
+	is.True(err != nil)
+}
+
+func TestAllowedCommands(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import "os/exec"
+
+func goscript(name string) (string, error) {
+	out, err := exec.Command(name, "hello").Output()
+	if err != nil {
+		return err.Error(), nil
+	}
+	return string(out), nil
+}
+`, WithAllowedCommands("echo"))
+	defer script.Close()
+	out, err := script.Execute("echo")
+	is.NoErr(err) // Execute echo
+	is.Equal(out, "hello\n")
+	out, err = script.Execute("ls")
+	is.NoErr(err) // Execute ls
+	is.Equal(out, `goscript: command "ls" is not allowed`)
+}
+
+func TestGoroutineLeakCheck(t *testing.T) {
+	is := is.New(t)
+	var leaks []int
+	script := New(`
+func goscript(leak bool) (string, error) {
+	if leak {
+		go func() {
+			select {}
+		}()
+	}
+	return "done", nil
+}
+`, WithGoroutineLeakCheck(0, func(growth int) {
+		leaks = append(leaks, growth)
+	}))
+	defer script.Close()
+	_, err := script.Execute(false)
+	is.NoErr(err) // Execute
+	is.Equal(len(leaks), 0)
+	for i := 0; i < 2; i++ {
+		_, err := script.Execute(true)
+		is.NoErr(err) // Execute leaking
+	}
+	is.Equal(leaks, []int{1, 1})
+}
+
+func TestExecuteTypedSlice(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript(n int) (interface{}, error) {
+	if n < 0 {
+		return "negative", nil
+	}
+	squares := make([]int, n)
+	for i := range squares {
+		squares[i] = i * i
+	}
+	return squares, nil
+}
+`)
+	defer script.Close()
+	squares, err := ExecuteTypedSlice[int](script, 4)
+	is.NoErr(err) // ExecuteTypedSlice
+	is.Equal(squares, []int{0, 1, 4, 9})
+	_, err = ExecuteTypedSlice[int](script, -1)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "result isn't a []int"))
+}
+
+func TestPoisoned(t *testing.T) {
+	is := is.New(t)
+	src := `
+import "syscall"
+
+func goscript(desync bool) (string, error) {
+	if desync {
+		// corrupts the protocol stream
+		syscall.Write(1, []byte("garbage"))
+	}
+	return "ok", nil
+}
+`
+	script := New(src)
+	defer script.Close()
+	is.True(script.Healthy())
+	_, err := script.Execute(true)
+	is.True(err != nil)
+	is.True(!script.Healthy())
+	_, err = script.Execute(false)
+	is.Equal(err, ErrPoisoned)
+	is.NoErr(script.Reload(src))
+	is.True(script.Healthy())
+	v, err := script.Execute(false)
+	is.NoErr(err) // Execute after Reload
+	is.Equal(v, "ok")
+}
+
+func TestReset(t *testing.T) {
+	for _, mode := range []ExecMode{GoRun, GoBuild} {
+		is := is.New(t)
+		script := New(`
+var calls int
+
+func goscript() (int, error) {
+	calls++
+	return calls, nil
+}
+`, WithExecMode(mode))
+		defer script.Close()
+		for i := 1; i <= 2; i++ {
+			n, err := script.Execute()
+			is.NoErr(err) // Execute
+			is.Equal(n, i)
+		}
+		is.NoErr(script.Reset())
+		n, err := script.Execute()
+		is.NoErr(err) // Execute after Reset
+		is.Equal(n, 1)
+	}
+}
+
+func TestPing(t *testing.T) {
+	is := is.New(t)
+	src := `
+import "syscall"
+
+func goscript(crash bool) (string, error) {
+	if crash {
+		syscall.Exit(1)
+	}
+	return "ok", nil
+}
+`
+	script := New(src)
+	defer script.Close()
+	is.NoErr(script.Ping()) // Ping
+	_, err := script.Execute(true)
+	is.True(err != nil)
+	is.True(script.Ping() != nil)
+	is.NoErr(script.Reload(src))
+	is.NoErr(script.Ping()) // Ping after Reload
+	v, err := script.Execute(false)
+	is.NoErr(err) // Execute
+	is.Equal(v, "ok")
+}
+
+func TestAutoRestart(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import "syscall"
+
+// goscript crashes the first time it is given a marker file, which it
+// creates, or every time if always is set.
+func goscript(marker string, always bool) (string, error) {
+	fd, err := syscall.Open(marker, syscall.O_CREAT|syscall.O_EXCL|syscall.O_WRONLY, 0600)
+	if err == nil {
+		syscall.Close(fd)
+	}
+	if err == nil || always {
+		syscall.Exit(1)
+	}
+	return "ok", nil
+}
+`, WithAutoRestart(), WithExecMode(GoBuild))
+	defer script.Close()
+	marker := filepath.Join(t.TempDir(), "crashed")
+	v, err := script.Execute(marker, false)
+	is.NoErr(err) // Execute retried after the crash
+	is.Equal(v, "ok")
+	for i := 0; i < maxAutoRestarts; i++ {
+		_, err = script.Execute(marker, true)
+		is.True(err != nil)
+		is.True(err != ErrTooManyRestarts)
+	}
+	_, err = script.Execute(marker, false)
+	is.Equal(err, ErrTooManyRestarts)
+}
+
+func TestExecuteContextCancel(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript(hang bool) (string, error) {
+	for hang {
+	}
+	return "done", nil
+}
+`)
+	defer script.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := script.ExecuteContext(ctx, true)
+	is.Equal(err, context.DeadlineExceeded)
+	is.True(time.Since(start) < 5*time.Second) // returned promptly
+	v, err := script.Execute(false)
+	is.NoErr(err) // Execute after cancelling
+	is.Equal(v, "done")
+}
+
+func TestNewScript(t *testing.T) {
+	is := is.New(t)
+	script, err := NewScript(`
+func goscript(name string) (string, error) {
+	return "Hello " + name, nil
+}
+`)
+	is.NoErr(err) // NewScript
+	defer script.Close()
+	greeting, err := script.Execute("Mat")
+	is.NoErr(err) // Execute
+	is.Equal(greeting, "Hello Mat")
+
+	script, err = NewScript(`
+func goscript(name string) (string, error) {
+	return 1, nil
+}
+`)
+	is.True(err != nil)
+	is.True(script == nil)
+	var gerr Error
+	is.True(errors.As(err, &gerr))
+}
+
+func TestGoBinary(t *testing.T) {
+	is := is.New(t)
+	goBinary, err := exec.LookPath("go")
+	is.NoErr(err) // find go
+	src := `
+func goscript(name string) (string, error) {
+	return "Hello " + name, nil
+}
+`
+	for _, mode := range []ExecMode{GoRun, GoBuild} {
+		script, err := NewScript(src, WithGoBinary(goBinary), WithExecMode(mode))
+		is.NoErr(err) // NewScript
+		greeting, err := script.Execute("Mat")
+		is.NoErr(err) // Execute
+		is.Equal(greeting, "Hello Mat")
+		script.Close()
+
+		missing := filepath.Join(t.TempDir(), "go")
+		_, err = NewScript(src, WithGoBinary(missing), WithExecMode(mode))
+		is.True(err != nil)
+		is.True(strings.Contains(err.Error(), missing))
+	}
+}
+
+func TestCall(t *testing.T) {
+	for _, codec := range []Codec{GobCodec, JSONCodec} {
+		is := is.New(t)
+		script := New(`
+import "strings"
+
+func goscript_upper(s string) (string, error) {
+	return strings.ToUpper(s), nil
+}
+
+func goscript_join(sep string, parts ...string) (string, error) {
+	return strings.Join(parts, sep), nil
+}
+`, WithCodec(codec))
+		defer script.Close()
+		v, err := script.Call("upper", "mat")
+		is.NoErr(err) // Call upper
+		is.Equal(v, "MAT")
+		v, err = script.Call("join", "-", "a", "b", "c")
+		is.NoErr(err) // Call join
+		is.Equal(v, "a-b-c")
+		_, err = script.Call("lower", "MAT")
+		is.True(err != nil)
+		is.Equal(err.Error(), "goscript: the script has no func goscript_lower")
+		_, err = script.Execute()
+		is.Equal(err, errNoGoscript)
+	}
+
+	is := is.New(t)
+	script := New(`
+func goscript(n int) (int, error) {
+	return n * 2, nil
+}
+
+func goscript_square(n int) (int, error) {
+	return n * n, nil
+}
+`, WithStrictArgs())
+	defer script.Close()
+	v, err := script.Execute(3)
+	is.NoErr(err) // Execute
+	is.Equal(v, 6)
+	v, err = script.Call("square", 3)
+	is.NoErr(err) // Call square
+	is.Equal(v, 9)
+	_, err = script.Call("square", "3")
+	is.Equal(err.Error(), "goscript: argument n: got string, want int")
+}
+
+// scriptParams gets the parameters of the script's func goscript.
+func scriptParams(t *testing.T, script string) []arg {
+	params, err := processScript(script, "goscript", nil)
+	if err != nil {
+		t.Fatal(err)
 	}
+	return params
+}
+
+func TestEntryPoint(t *testing.T) {
+	is := is.New(t)
+	script, err := NewScript(`
+import "strings"
+
+func wordCount(s string) (int, error) {
+	return len(strings.Fields(s)), nil
+}
+
+func goscript_upper(s string) (string, error) {
+	return strings.ToUpper(s), nil
+}
+`, WithEntryPoint("wordCount"))
+	is.NoErr(err) // NewScript
+	defer script.Close()
+	n, err := script.Execute("the quick brown fox")
+	is.NoErr(err) // Execute
+	is.Equal(n, 4)
+	v, err := script.Call("upper", "fox")
+	is.NoErr(err) // Call
+	is.Equal(v, "FOX")
+
+	_, err = NewScript(`
+func goscript(s string) (int, error) {
+	return len(s), nil
+}
+`, WithEntryPoint("wordCount"))
+	is.True(err != nil)
+	is.Equal(err.Error(), "missing func wordCount")
+
+	_, err = NewScript(`
+func init() {}
+`, WithEntryPoint("init"))
+	is.True(err != nil)
+	is.Equal(err.Error(), `goscript: the entry point can't be named "init"`)
+}
+
+func TestMixedParams(t *testing.T) {
+	is := is.New(t)
+	// the go command isn't run, so the error is found before compiling
+	_, err := NewScript(`
+func goscript(a, b string, c) (string, error) {
+	return a + b, nil
+}
+`, WithGoBinary(filepath.Join(t.TempDir(), "go")))
+	is.True(err != nil)
+	is.Equal(err.Error(), "goscript:2: syntax error: mixed named and unnamed function parameters")
+}
+
+func TestMultiLineSignature(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import "strings"
+
+func goscript(
+	salutation, name string,
+	times int,
+) (string, error) {
+	return strings.Repeat(salutation+" "+name+"! ", times), nil
+}
+`)
+	defer script.Close()
+	greeting, err := script.Execute("Hello", "Mat", 2)
+	is.NoErr(err) // Execute
+	is.Equal(greeting, "Hello Mat! Hello Mat! ")
 }
 
-func TestExtractArguments(t *testing.T) {
+func TestScriptParams(t *testing.T) {
 	is := is.New(t)
 
-	in := extractArguments(`func goscript(one, two, three string, age int) (interface{}, error)`)
+	in := scriptParams(t, `func goscript(one, two, three string, age int) (interface{}, error) { return nil, nil }`)
 
 	is.Equal(len(in), 4)
 	is.Equal(in[0].Index, 0)
@@ -122,13 +1964,32 @@ func TestExtractArguments(t *testing.T) {
 	is.Equal(in[3].Name, "age")
 	is.Equal(in[3].Typ, "int")
 
-	in = extractArguments(`func goscript(args ...interface{}) (interface{}, error)`)
+	in = scriptParams(t, `func goscript(args ...interface{}) (interface{}, error) { return nil, nil }`)
 	is.Equal(len(in), 1)
 	is.Equal(in[0].Index, 0)
 	is.Equal(in[0].Name, "args")
 	is.Equal(in[0].Typ, "...interface{}")
 
-	in = extractArguments(`func goscript() (interface{}, error)`)
+	in = scriptParams(t, `func goscript() (interface{}, error) { return nil, nil }`)
 	is.Equal(len(in), 0)
 
+	in = scriptParams(t, `func goscript(setProgress func(int), names map[string]interface{}) (interface{}, error) { return nil, nil }`)
+	is.Equal(len(in), 2)
+	is.Equal(in[0].Name, "setProgress")
+	is.Equal(in[0].Typ, "func(int)")
+	is.True(in[0].Progress())
+	is.Equal(in[1].Name, "names")
+	is.Equal(in[1].Typ, "map[string]interface{}")
+	is.Equal(in[1].ArgIndex, 0)
+
+	// only the declaration counts, not comments or strings
+	in = scriptParams(t, "// func goscript(a int) (int, error)\nvar usage = `\nfunc goscript(b int) (int, error)`\n\nfunc goscript(name string) (string, error) { return name, nil }")
+	is.Equal(len(in), 1)
+	is.Equal(in[0].Name, "name")
+
+	in = scriptParams(t, `func goscript(_ string, f func(a, b int) int) (int, error) { return f(1, 2), nil }`)
+	is.Equal(len(in), 2)
+	is.Equal(in[0].Name, "goscriptArg0")
+	is.Equal(in[1].Name, "f")
+	is.Equal(in[1].Typ, "func(a, b int) int")
 }
@@ -0,0 +1,64 @@
+package goscript
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Bucket is a bucket of a latency histogram.
+type Bucket struct {
+	// UpperBound is the longest latency counted in the bucket. The
+	// last bucket has no upper bound, and UpperBound is the maximum
+	// Duration.
+	UpperBound time.Duration
+	// Count is the number of calls that took longer than the
+	// previous bucket's UpperBound, and no longer than this one's.
+	Count int64
+}
+
+// latencyBounds are the upper bounds of the histogram buckets.
+var latencyBounds = [...]time.Duration{
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	math.MaxInt64,
+}
+
+// latencyHistogram counts calls by latency.
+// The counts are accessed atomically.
+type latencyHistogram [len(latencyBounds)]int64
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	for i, bound := range latencyBounds {
+		if d <= bound {
+			atomic.AddInt64(&h[i], 1)
+			return
+		}
+	}
+}
+
+// LatencyHistogram gets the number of calls to the script that took
+// each range of time, including the round trip to the script process.
+// The buckets are fixed, from 1ms up to 10s and beyond, and are
+// returned in order.
+func (s *Script) LatencyHistogram() []Bucket {
+	buckets := make([]Bucket, len(latencyBounds))
+	for i, bound := range latencyBounds {
+		buckets[i] = Bucket{
+			UpperBound: bound,
+			Count:      atomic.LoadInt64(&s.latency[i]),
+		}
+	}
+	return buckets
+}
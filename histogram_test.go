@@ -0,0 +1,38 @@
+package goscript
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import "time"
+
+func goscript(ms int) (int, error) {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	return ms, nil
+}
+`)
+	defer script.Close()
+	for _, ms := range []int{0, 0, 0, 300} {
+		_, err := script.Execute(ms)
+		is.NoErr(err) // Execute
+	}
+	var fast, slow, total int64
+	for _, b := range script.LatencyHistogram() {
+		total += b.Count
+		if b.UpperBound <= 100*time.Millisecond {
+			fast += b.Count
+		}
+		if b.UpperBound > 300*time.Millisecond {
+			slow += b.Count
+		}
+	}
+	is.Equal(total, int64(4))
+	is.Equal(fast, int64(3))
+	is.Equal(slow, int64(1))
+}
@@ -0,0 +1,95 @@
+package goscript
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// WithAllowedImports restricts the packages the script can import to
+// those listed, by import path, such as "strings" or "path/filepath".
+// New fails if the script, or an extra source file, imports any other
+// package, or uses the names goscript gives the packages it imports
+// for itself, such as goscriptos.
+// Imports are found by parsing the source, so import paths in
+// comments and strings aren't mistaken for imports. This limits what
+// a script can do only as far as the allowed packages do; use
+// WithSandbox as well for scripts that can't be trusted.
+func WithAllowedImports(pkgs ...string) Option {
+	return func(o *options) {
+		o.restrictImports = true
+		o.allowedImports = append(o.allowedImports, pkgs...)
+	}
+}
+
+// checkAllowedImports checks the script and extra source files only
+// use the allowed packages, and don't use those the harness imports
+// through the names it gives them.
+func checkAllowedImports(script string, opts options) error {
+	allowed := make(map[string]bool)
+	for _, pkg := range opts.allowedImports {
+		allowed[pkg] = true
+	}
+	// the script has no package clause, so one is added, and lines
+	// are reported as they are in the script
+	files := []importFile{{name: "goscript", src: "package main\n" + script, lineOffset: 1}}
+	for _, sf := range opts.sourceFiles {
+		files = append(files, importFile{name: sf.name, src: sf.src})
+	}
+	declared := make(map[string]bool)
+	for i := range files {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, files[i].name, files[i].src, 0)
+		if err != nil {
+			// let the compiler report it
+			continue
+		}
+		files[i].fset, files[i].f = fset, f
+		for _, ident := range declaredNames(f) {
+			declared[ident.Name] = true
+		}
+	}
+	for _, file := range files {
+		if file.f == nil {
+			continue
+		}
+		if err := file.check(allowed, declared); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importFile is a source file whose imports are checked.
+type importFile struct {
+	name string
+	src  string
+	// lineOffset is taken from line numbers in errors.
+	lineOffset int
+	fset       *token.FileSet
+	f          *ast.File
+}
+
+// check checks the file only imports allowed packages, and only uses
+// names starting with goscript that are declared by the script or
+// its extra source files, rather than by the harness.
+func (file importFile) check(allowed, declared map[string]bool) error {
+	line := func(pos token.Pos) int {
+		return file.fset.Position(pos).Line - file.lineOffset
+	}
+	for _, spec := range file.f.Imports {
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		if !allowed[importPath] {
+			return fmt.Errorf("%s:%d: import %q is not allowed", file.name, line(spec.Pos()), importPath)
+		}
+	}
+	for _, ident := range file.f.Unresolved {
+		if strings.HasPrefix(ident.Name, "goscript") && !declared[ident.Name] {
+			return fmt.Errorf("%s:%d: %q is reserved by goscript", file.name, line(ident.Pos()), ident.Name)
+		}
+	}
+	return nil
+}
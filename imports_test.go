@@ -0,0 +1,79 @@
+package goscript
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestAllowedImports(t *testing.T) {
+	is := is.New(t)
+	allowed := WithAllowedImports("strings", "path/filepath")
+	script, err := NewScript(`
+import (
+	"path/filepath"
+	"strings"
+)
+
+// the "os/exec" and "net/http" packages are mentioned, not imported
+func goscript(name string) (string, error) {
+	return strings.ToUpper(filepath.Base(name)), nil
+}
+`, allowed)
+	is.NoErr(err) // NewScript
+	defer script.Close()
+	v, err := script.Execute("/tmp/mat")
+	is.NoErr(err) // Execute
+	is.Equal(v, "MAT")
+
+	_, err = NewScript(`
+import (
+	"strings"
+	"os/exec"
+)
+
+func goscript() (string, error) {
+	out, err := exec.Command("id").Output()
+	return strings.TrimSpace(string(out)), err
+}
+`, allowed)
+	is.True(err != nil)
+	is.Equal(err.Error(), `goscript:4: import "os/exec" is not allowed`)
+
+	// the packages goscript imports are hidden from scripts, so
+	// they can't be used without importing them
+	_, err = NewScript(`
+func goscript() (string, error) {
+	return "", os.RemoveAll("/tmp/nothing")
+}
+`, allowed)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "undefined: os"))
+
+	// nor can they be used through the names goscript gives them
+	_, err = NewScript(`
+func goscript() (string, error) {
+	b, err := goscriptos.ReadFile("/etc/hostname")
+	return string(b), err
+}
+`, allowed)
+	is.True(err != nil)
+	is.Equal(err.Error(), `goscript:3: "goscriptos" is reserved by goscript`)
+
+	_, err = NewScript(`
+func goscript() (string, error) {
+	return helper()
+}
+`, allowed, WithExtraFile("helper.go", `package main
+
+import "net/http"
+
+func helper() (string, error) {
+	_, err := http.Get("http://example.com")
+	return "", err
+}
+`))
+	is.True(err != nil)
+	is.Equal(err.Error(), `helper.go:3: import "net/http" is not allowed`)
+}
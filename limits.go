@@ -0,0 +1,77 @@
+package goscript
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrResourceLimit is the Err of the Error returned when a script is
+// killed for exceeding its ResourceLimits.
+var ErrResourceLimit = errors.New("goscript: script killed for exceeding its resource limits")
+
+// ResourceLimits limits the resources the script process can use.
+// Zero values mean no limit.
+type ResourceLimits struct {
+	// MaxMemoryBytes limits the memory the script process can map
+	// for its heap and other data (RLIMIT_DATA), which includes
+	// memory that is mapped but not yet used, so it should allow
+	// some room above what the script needs. RLIMIT_AS isn't used,
+	// since the Go runtime reserves far more address space than it
+	// uses.
+	MaxMemoryBytes int64
+	// MaxCPUSeconds limits the CPU time used by the script process
+	// (RLIMIT_CPU), across all its threads and calls.
+	MaxCPUSeconds int64
+}
+
+// WithResourceLimits limits the memory and CPU time the script process
+// can use, so that a runaway script is killed rather than taking the
+// caller down with it. Calls that end this way return an Error whose
+// Err is ErrResourceLimit.
+// Limits are set by the script process as it starts, before the
+// script's package-level variables are initialized and its init
+// functions run. They are best-effort: the kernel's accounting decides
+// when a limit is exceeded, and memory is counted as it is mapped
+// rather than as it is used.
+// Only Linux is supported; on other platforms New fails with an error.
+// Setting it makes GoRun behave as GoBuild, so that the go tool isn't
+// limited too (see WithExecMode).
+func WithResourceLimits(limits ResourceLimits) Option {
+	return func(o *options) {
+		o.limits = &limits
+	}
+}
+
+// exceededLimits reports whether the script process, which exited
+// with state and wrote stderr, was killed for exceeding the limits.
+func exceededLimits(limits *ResourceLimits, state *os.ProcessState, stderr string) bool {
+	if limits == nil || state == nil {
+		return false
+	}
+	if limits.MaxMemoryBytes > 0 && outOfMemory(stderr) {
+		return true
+	}
+	if limits.MaxCPUSeconds > 0 && killedByCPULimit(state) {
+		// the process may be killed for other reasons, so it
+		// must have used up its CPU time too
+		cpu := state.UserTime() + state.SystemTime()
+		return cpu >= time.Duration(limits.MaxCPUSeconds)*time.Second-cpuLimitSlack
+	}
+	return false
+}
+
+// cpuLimitSlack allows for the CPU times reported for the process
+// being sampled at each clock tick, which can leave them short of the
+// time the kernel counted by more than a tenth of a second when the
+// machine is busy.
+const cpuLimitSlack = 250 * time.Millisecond
+
+// outOfMemory reports whether the Go runtime's stderr says it ran out
+// of memory, which it reports differently depending on what it was
+// allocating.
+func outOfMemory(stderr string) bool {
+	return strings.Contains(stderr, "runtime: out of memory") ||
+		strings.Contains(stderr, "fatal error: out of memory")
+}
@@ -0,0 +1,19 @@
+//go:build linux
+
+package goscript
+
+import (
+	"os"
+	"syscall"
+)
+
+func checkResourceLimits() error {
+	return nil
+}
+
+// killedByCPULimit reports whether the process was killed in the way
+// the kernel kills processes that reach their hard CPU limit.
+func killedByCPULimit(state *os.ProcessState) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == syscall.SIGKILL
+}
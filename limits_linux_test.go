@@ -0,0 +1,61 @@
+//go:build linux
+
+package goscript
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestResourceLimits(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+var kept [][]byte
+
+func goscript(mb int, spin bool) (int, error) {
+	for i := 0; i < mb; i++ {
+		b := make([]byte, 1<<20)
+		b[0] = 1
+		kept = append(kept, b)
+	}
+	for spin {
+	}
+	return len(kept), nil
+}
+`, WithResourceLimits(ResourceLimits{MaxMemoryBytes: 256 << 20, MaxCPUSeconds: 1}))
+	defer script.Close()
+	n, err := script.Execute(10, false)
+	is.NoErr(err) // Execute within the limits
+	is.Equal(n, 10)
+
+	_, err = script.Execute(1024, false)
+	is.True(errors.Is(err, ErrResourceLimit)) // memory
+
+	is.NoErr(script.Reset())
+	_, err = script.Execute(0, true)
+	is.True(errors.Is(err, ErrResourceLimit)) // CPU
+}
+
+func TestResourceLimitsInitializers(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+var kept = func() [][]byte {
+	var kept [][]byte
+	for i := 0; i < 1024; i++ {
+		b := make([]byte, 1<<20)
+		b[0] = 1
+		kept = append(kept, b)
+	}
+	return kept
+}()
+
+func goscript() (int, error) {
+	return len(kept), nil
+}
+`, WithResourceLimits(ResourceLimits{MaxMemoryBytes: 256 << 20}))
+	defer script.Close()
+	_, err := script.Execute()
+	is.True(errors.Is(err, ErrResourceLimit)) // package-level variables are limited too
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package goscript
+
+import (
+	"errors"
+	"os"
+)
+
+func checkResourceLimits() error {
+	return errors.New("goscript: resource limits are only supported on linux")
+}
+
+func killedByCPULimit(state *os.ProcessState) bool {
+	return false
+}
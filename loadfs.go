@@ -0,0 +1,94 @@
+package goscript
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Scripts holds scripts by name, as loaded by LoadFS.
+type Scripts map[string]*Script
+
+// Close closes all the scripts, and returns the first error, by name.
+func (s Scripts) Close() error {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var firstErr error
+	for _, name := range names {
+		if err := s[name].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// scriptExt is the file extension of scripts loaded by LoadFS.
+const scriptExt = ".goscript"
+
+// LoadFS starts each of the .goscript files in the directory in fsys,
+// such as an embed.FS, with the options, and returns them keyed by
+// their file names without the extension:
+//
+//	//go:embed scripts
+//	var scriptsFS embed.FS
+//
+//	scripts, err := goscript.LoadFS(scriptsFS, "scripts")
+//	if err != nil {
+//		return err
+//	}
+//	defer scripts.Close()
+//	greeting, err := scripts["greet"].Execute("Mat")
+//
+// Other files and subdirectories are ignored. The scripts are started
+// concurrently, and if any of them can't be started, the others are
+// closed and the error is returned.
+func LoadFS(fsys fs.FS, dir string, opts ...Option) (Scripts, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("goscript: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != scriptExt {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	scripts := make(Scripts, len(names))
+	errs := make([]error, len(names))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, file := range names {
+		wg.Add(1)
+		go func(i int, file string) {
+			defer wg.Done()
+			src, err := fs.ReadFile(fsys, path.Join(dir, file))
+			if err != nil {
+				errs[i] = fmt.Errorf("goscript: %w", err)
+				return
+			}
+			script, err := NewScript(string(src), opts...)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", file, err)
+				return
+			}
+			mu.Lock()
+			scripts[strings.TrimSuffix(file, scriptExt)] = script
+			mu.Unlock()
+		}(i, file)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			scripts.Close()
+			return nil, err
+		}
+	}
+	return scripts, nil
+}
@@ -0,0 +1,56 @@
+package goscript
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+func TestLoadFS(t *testing.T) {
+	is := is.New(t)
+	fsys := fstest.MapFS{
+		"scripts/greet.goscript": {Data: []byte(`
+func goscript(name string) (string, error) {
+	return "Hello " + name, nil
+}
+`)},
+		"scripts/shout.goscript": {Data: []byte(`
+import "strings"
+
+func goscript(s string) (string, error) {
+	return strings.ToUpper(s), nil
+}
+`)},
+		"scripts/README.md":               {Data: []byte("not a script")},
+		"scripts/nested/skipped.goscript": {Data: []byte("not compiled")},
+	}
+	scripts, err := LoadFS(fsys, "scripts")
+	is.NoErr(err) // LoadFS
+	defer scripts.Close()
+	is.Equal(len(scripts), 2)
+	v, err := scripts["greet"].Execute("Mat")
+	is.NoErr(err) // greet
+	is.Equal(v, "Hello Mat")
+	v, err = scripts["shout"].Execute("hi")
+	is.NoErr(err) // shout
+	is.Equal(v, "HI")
+	is.NoErr(scripts.Close())
+
+	fsys["scripts/broken.goscript"] = &fstest.MapFile{Data: []byte(`
+func goscript() (string, error) {
+	return undefinedName, nil
+}
+`)}
+	_, err = LoadFS(fsys, "scripts")
+	is.True(err != nil) // a script doesn't compile
+	is.True(strings.HasPrefix(err.Error(), "broken.goscript: "))
+	var scriptErr Error
+	is.True(errors.As(err, &scriptErr))
+	is.True(strings.Contains(scriptErr.Stderr, "undefined: undefinedName"))
+
+	_, err = LoadFS(fsys, "missing")
+	is.True(err != nil) // missing directory
+}
@@ -0,0 +1,73 @@
+package goscript
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// WithModule compiles the script as the main package of a module with
+// the path, which requires the modules listed, so the script can
+// import packages other than the standard library's. Each requirement
+// is a module path and version, as in a go.mod require directive:
+//
+//	script := goscript.New(src, goscript.WithModule("example.com/script",
+//		"github.com/google/uuid v1.6.0",
+//	))
+//
+// Modules are downloaded by the go command into the module cache, and
+// their checksums verified against the checksum database, as set up by
+// the environment (GOPROXY, GOFLAGS, and so on).
+// Setting it makes GoRun behave as GoBuild, so that the script runs in
+// the caller's working directory rather than the module's.
+func WithModule(path string, requires ...string) Option {
+	return func(o *options) {
+		o.module = &module{path: path, requires: requires}
+	}
+}
+
+// module is the module the script is compiled in.
+type module struct {
+	path     string
+	requires []string
+}
+
+// goMod generates the go.mod file for the module, for the version of
+// Go described by goVersion, the output of go version.
+func (m module) goMod(goVersion string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "module %s\n", m.path)
+	if v := goDirective(goVersion); v != "" {
+		fmt.Fprintf(&buf, "\ngo %s\n", v)
+	}
+	if len(m.requires) > 0 {
+		buf.WriteString("\nrequire (\n")
+		for _, req := range m.requires {
+			fmt.Fprintf(&buf, "\t%s\n", req)
+		}
+		buf.WriteString(")\n")
+	}
+	return buf.Bytes()
+}
+
+// goDirective gets the language version for a go directive from the
+// output of go version, such as 1.21 from
+// "go version go1.21.5 linux/amd64", or an empty string if there is
+// none.
+func goDirective(goVersion string) string {
+	fields := strings.Fields(goVersion)
+	if len(fields) < 3 || !strings.HasPrefix(fields[2], "go1.") {
+		return ""
+	}
+	parts := strings.SplitN(strings.TrimPrefix(fields[2], "go"), ".", 3)
+	// the minor version may be followed by a pre-release, as in
+	// go1.22rc1
+	minor := parts[1]
+	if i := strings.IndexFunc(minor, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		minor = minor[:i]
+	}
+	if minor == "" {
+		return ""
+	}
+	return parts[0] + "." + minor
+}
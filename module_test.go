@@ -0,0 +1,56 @@
+package goscript
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestModule(t *testing.T) {
+	is := is.New(t)
+	script, err := NewScript(`
+import "runtime/debug"
+
+func goscript() (string, error) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "", nil
+	}
+	return info.Main.Path, nil
+}
+`, WithModule("example.com/script"))
+	is.NoErr(err) // NewScript
+	defer script.Close()
+	path, err := script.Execute()
+	is.NoErr(err) // Execute
+	is.Equal(path, "example.com/script")
+
+	_, err = NewScript(`
+func goscript() (int, error) {
+	return missing, nil
+}
+`, WithModule("example.com/script"))
+	is.True(err != nil)
+	is.Equal(err.Error(), "goscript:3:9: undefined: missing")
+}
+
+func TestGoMod(t *testing.T) {
+	is := is.New(t)
+	m := module{
+		path:     "example.com/script",
+		requires: []string{"github.com/google/uuid v1.6.0", "golang.org/x/text v0.14.0"},
+	}
+	is.Equal(string(m.goMod("go version go1.21.5 linux/amd64")), `module example.com/script
+
+go 1.21
+
+require (
+	github.com/google/uuid v1.6.0
+	golang.org/x/text v0.14.0
+)
+`)
+	m = module{path: "example.com/script"}
+	is.Equal(string(m.goMod("")), "module example.com/script\n")
+	is.Equal(goDirective("go version go1.22rc1 darwin/arm64"), "1.22")
+	is.Equal(goDirective("go version devel +abc123 linux/amd64"), "")
+}
@@ -0,0 +1,52 @@
+package goscript
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ExecuteMulti executes the script with the specified arguments, where
+// the goscript function returns several values before its error, and
+// returns the values in order:
+//
+//	func goscript(s string) (int, string, error)
+//
+//	values, err := script.ExecuteMulti("hello")
+//
+// Execute returns the same values, as a []interface{}. For scripts
+// that return a single value, it is the only element.
+func (s *Script) ExecuteMulti(args ...interface{}) ([]interface{}, error) {
+	res, err := s.execute(context.Background(), request{Args: args}, false)
+	if err != nil {
+		return nil, err
+	}
+	if !res.MultiValue {
+		return []interface{}{res.Value}, res.Error
+	}
+	values, _ := res.Value.([]interface{})
+	return values, res.Error
+}
+
+// resultsList lists names for the values returned by the function
+// named entry before its error, if there is more than one, for the
+// harness to collect them in.
+func resultsList(script, entry string) string {
+	funcs, _ := parseScript(script, entry)
+	if funcs.goscript == nil || funcs.goscript.results < 3 {
+		return ""
+	}
+	names := make([]string, funcs.goscript.results-1)
+	for i := range names {
+		names[i] = fmt.Sprintf("goscriptResult%d", i)
+	}
+	return strings.Join(names, ", ")
+}
+
+// errorOnly reports whether the function named entry returns only an
+// error, such as func goscript() error, in which case calls return a
+// nil value.
+func errorOnly(script, entry string) bool {
+	funcs, _ := parseScript(script, entry)
+	return funcs.goscript != nil && funcs.goscript.results == 1
+}
@@ -0,0 +1,80 @@
+package goscript
+
+import (
+	"context"
+	"errors"
+)
+
+// ExecuteNamed executes a script that takes a struct of parameters,
+// declared in the script, setting its fields by name from params,
+// which is clearer than passing many arguments in order, and leaves
+// the fields that aren't named as their zero values:
+//
+//	type Params struct {
+//		Query string
+//		Limit int
+//		Fuzzy bool
+//	}
+//
+//	func goscript(params Params) ([]string, error)
+//
+//	results, err := script.ExecuteNamed(map[string]interface{}{
+//		"Query": "gopher",
+//		"Limit": 10,
+//	})
+//
+// Names are matched to fields ignoring case, and an error is returned
+// if the struct has no field with a name, or a value isn't of its
+// field's type. The struct is the only argument; the script can also
+// take a context, deadline, progress or yield function as with
+// Execute, and can still be called with Execute and a Params value.
+func (s *Script) ExecuteNamed(params map[string]interface{}) (interface{}, error) {
+	res, err := s.execute(context.Background(), request{Args: []interface{}{params}, Named: true}, false)
+	if err != nil {
+		return nil, err
+	}
+	return res.Value, res.Error
+}
+
+// errNotNamed is returned by ExecuteNamed for scripts that don't take
+// a struct of parameters.
+var errNotNamed = errors.New("goscript: func goscript must take a struct declared in the script to use ExecuteNamed")
+
+// namedParam gets the name of the parameter of the entry point that
+// ExecuteNamed sets the fields of, which is its only argument, of a
+// struct type declared in the script, or an empty string if it has no
+// such parameter.
+func namedParam(script, entry string) string {
+	funcs, _ := parseScript(script, entry)
+	if funcs.goscript == nil {
+		return ""
+	}
+	var named []arg
+	for _, param := range funcs.goscript.params {
+		if !param.Provided() {
+			named = append(named, param)
+		}
+	}
+	if len(named) != 1 {
+		return ""
+	}
+	for _, typ := range structTypes(script) {
+		if named[0].Typ == typ {
+			return named[0].Name
+		}
+	}
+	return ""
+}
+
+// namedValues gets the values of the named parameters in the args of
+// a call made by ExecuteNamed, for registering their types.
+func namedValues(args []interface{}) []interface{} {
+	var values []interface{}
+	for _, a := range args {
+		params, _ := a.(map[string]interface{})
+		for _, v := range params {
+			values = append(values, v)
+		}
+	}
+	return values
+}
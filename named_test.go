@@ -0,0 +1,64 @@
+package goscript
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestExecuteNamed(t *testing.T) {
+	for _, codec := range []Codec{GobCodec, JSONCodec} {
+		is := is.New(t)
+		script := New(`
+import "strings"
+
+type Params struct {
+	Name     string
+	Greeting string
+	Times    int
+}
+
+func goscript(params Params) (string, error) {
+	if params.Greeting == "" {
+		params.Greeting = "Hello"
+	}
+	if params.Times == 0 {
+		params.Times = 1
+	}
+	return strings.Repeat(params.Greeting+" "+params.Name+". ", params.Times), nil
+}
+`, WithCodec(codec))
+		defer script.Close()
+
+		v, err := script.ExecuteNamed(map[string]interface{}{
+			"Name":  "Mat",
+			"times": 2,
+		})
+		is.NoErr(err)                         // ExecuteNamed
+		is.Equal(v, "Hello Mat. Hello Mat. ") // named values, and defaults
+
+		_, err = script.ExecuteNamed(map[string]interface{}{
+			"Nmae": "Mat",
+		})
+		is.True(err != nil)                            // unknown field
+		is.True(strings.Contains(err.Error(), "Nmae")) // names the field
+
+		_, err = script.ExecuteNamed(map[string]interface{}{
+			"Times": "two",
+		})
+		is.True(err != nil) // wrong type
+	}
+}
+
+func TestExecuteNamedNotStruct(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript(name string) (string, error) {
+	return name, nil
+}
+`)
+	defer script.Close()
+	_, err := script.ExecuteNamed(map[string]interface{}{"name": "Mat"})
+	is.Equal(err, errNotNamed)
+}
@@ -0,0 +1,53 @@
+package goscript
+
+import "time"
+
+// Observer is told about the script processes a Script runs and the
+// calls made to them, for collecting metrics such as call latency
+// and how often scripts are restarted, without goscript depending on
+// a metrics library.
+// Methods are called synchronously, so they should return quickly.
+// An Observer shared by several scripts, such as those in a Pool, is
+// called concurrently.
+type Observer interface {
+	// OnStart is called each time a script process is started, with
+	// how long it took to be ready, including compiling the script
+	// with go run, and the error if it failed to start. Starts
+	// after the first are restarts.
+	OnStart(d time.Duration, err error)
+	// OnExecute is called after each call to the script, with how
+	// long it took, and the error it returned, if any.
+	OnExecute(d time.Duration, err error)
+	// OnClose is called when a script process that started is
+	// closed, such as when it is replaced by a restart, with the
+	// error Close returns for it.
+	OnClose(err error)
+}
+
+// WithObserver sets an Observer to be told about the script's
+// processes and calls.
+func WithObserver(o Observer) Option {
+	return func(opts *options) {
+		opts.observer = o
+	}
+}
+
+// observeStart tells the observer, if there is one, that the process
+// started at start has finished starting, with err if it failed.
+func (p *process) observeStart(start time.Time, err error) {
+	if p.observer != nil {
+		p.observer.OnStart(time.Since(start), err)
+	}
+}
+
+// callErr gets the error a call with the response, and the error
+// from making it, returns to the caller.
+func callErr(res response, err error) error {
+	switch {
+	case err != nil:
+		return err
+	case res.Panicked:
+		return PanicError{Value: res.Panic, Stack: res.Stack}
+	}
+	return res.Error
+}
@@ -0,0 +1,73 @@
+package goscript
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// countingObserver counts the events it is told about.
+type countingObserver struct {
+	mu         sync.Mutex
+	starts     int
+	executions int
+	failures   int
+	closes     int
+}
+
+func (o *countingObserver) OnStart(d time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.starts++
+}
+
+func (o *countingObserver) OnExecute(d time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.executions++
+	if err != nil {
+		o.failures++
+	}
+}
+
+func (o *countingObserver) OnClose(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closes++
+}
+
+func (o *countingObserver) counts() [4]int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return [4]int{o.starts, o.executions, o.failures, o.closes}
+}
+
+func TestObserver(t *testing.T) {
+	is := is.New(t)
+	obs := &countingObserver{}
+	script, err := NewScript(`
+func goscript(fail bool) (string, error) {
+	if fail {
+		panic("failed")
+	}
+	return "ok", nil
+}
+`, WithObserver(obs))
+	is.NoErr(err) // NewScript
+	_, err = script.Execute(false)
+	is.NoErr(err) // Execute
+	_, err = script.Execute(true)
+	var panicErr PanicError
+	is.True(errors.As(err, &panicErr))
+	is.Equal(obs.counts(), [4]int{1, 2, 1, 0})
+
+	// the old process is closed once the new one has started
+	is.NoErr(script.Reset())
+	is.Equal(obs.counts(), [4]int{2, 2, 1, 1})
+
+	is.NoErr(script.Close())
+	is.Equal(obs.counts(), [4]int{2, 2, 1, 2})
+}
@@ -0,0 +1,159 @@
+package goscript
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+// scriptFuncs holds the functions a script declares for goscript to
+// call.
+type scriptFuncs struct {
+	// goscript is func goscript, or the function named by
+	// WithEntryPoint, or nil if the script doesn't declare it.
+	goscript *scriptFunc
+	// entries holds the functions named goscript_ followed by a
+	// name, in the order they are declared.
+	entries []scriptFunc
+}
+
+// scriptFunc is a function declared by a script.
+type scriptFunc struct {
+	// name is the name of an entry point, without the goscript_
+	// prefix.
+	name   string
+	params []arg
+	// results is how many values the function returns, including
+	// its error.
+	results int
+	// typeParams names the function's type parameters, if it is
+	// generic.
+	typeParams []string
+}
+
+// parseScript parses the script to find the functions goscript calls,
+// where entry is the name of the function Execute calls.
+// It returns the error if the script doesn't parse, which is usually
+// left for the compiler to report.
+func parseScript(script, entry string) (scriptFuncs, error) {
+	src := "package main\n" + script
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "goscript.go", src, 0)
+	if err != nil {
+		return scriptFuncs{}, err
+	}
+	var funcs scriptFuncs
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil {
+			continue
+		}
+		sf := scriptFunc{
+			params:  funcArgs(fset, src, fn.Type.Params),
+			results: fn.Type.Results.NumFields(),
+		}
+		if fn.Type.TypeParams != nil {
+			for _, field := range fn.Type.TypeParams.List {
+				for _, ident := range field.Names {
+					sf.typeParams = append(sf.typeParams, ident.Name)
+				}
+			}
+		}
+		switch {
+		case fn.Name.Name == entry:
+			funcs.goscript = &sf
+		case strings.HasPrefix(fn.Name.Name, "goscript_"):
+			sf.name = strings.TrimPrefix(fn.Name.Name, "goscript_")
+			funcs.entries = append(funcs.entries, sf)
+		}
+	}
+	return funcs, nil
+}
+
+// mixedParamsError gets an error for the script if err, from parsing
+// it, is for a parameter list that mixes named and unnamed parameters,
+// such as func goscript(sep string, items), so it is reported without
+// waiting for the script to compile. Otherwise it returns nil.
+func mixedParamsError(err error) error {
+	list, ok := err.(scanner.ErrorList)
+	if !ok || len(list) == 0 {
+		return nil
+	}
+	switch list[0].Msg {
+	case "missing parameter type", "missing parameter name", "mixed named and unnamed parameters":
+		// the line is reported as it is in the script, which has no
+		// package clause
+		return fmt.Errorf("goscript:%d: syntax error: mixed named and unnamed function parameters", list[0].Pos.Line-1)
+	}
+	return nil
+}
+
+// funcArgs gets the parameters in the list, with their types as they
+// are written in src.
+// Unnamed and blank parameters are given names, so the harness can
+// pass them on.
+func funcArgs(fset *token.FileSet, src string, params *ast.FieldList) []arg {
+	if params == nil {
+		return nil
+	}
+	text := func(n ast.Node) string {
+		return src[fset.Position(n.Pos()).Offset:fset.Position(n.End()).Offset]
+	}
+	var args []arg
+	for _, field := range params.List {
+		typ := text(field.Type)
+		names := field.Names
+		if len(names) == 0 {
+			names = []*ast.Ident{{Name: "_"}}
+		}
+		for _, ident := range names {
+			name := ident.Name
+			if name == "_" {
+				name = fmt.Sprintf("goscriptArg%d", len(args))
+			}
+			args = append(args, arg{Index: len(args), Name: name, Typ: typ})
+		}
+	}
+	argIndex := 0
+	for i := range args {
+		if args[i].Provided() {
+			continue
+		}
+		args[i].ArgIndex = argIndex
+		argIndex++
+	}
+	return args
+}
+
+// startCall is declared first in the file whose package-level
+// variables are initialized first, so that goscriptStart runs before
+// any of the script's are.
+const startCall = "var _ = goscriptStart()"
+
+// insertStart adds startCall to the Go file src after its imports. It
+// goes on the same line, followed by a line directive, so the code
+// after it keeps its position.
+func insertStart(src []byte) []byte {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ImportsOnly)
+	if err != nil {
+		// let the compiler report it
+		return src
+	}
+	end := f.Name.End()
+	if len(f.Decls) > 0 {
+		end = f.Decls[len(f.Decls)-1].End()
+	}
+	// the position is as line directives in src give it, such as
+	// those around the script in the generated source
+	pos := fset.Position(end)
+	var out bytes.Buffer
+	out.Write(src[:pos.Offset])
+	fmt.Fprintf(&out, ";%s/*line :%d:%d*/", startCall, pos.Line, pos.Column)
+	out.Write(src[pos.Offset:])
+	return out.Bytes()
+}
@@ -0,0 +1,119 @@
+package goscript
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os/exec"
+	"strconv"
+)
+
+// Pipe runs a script that filters a stream of bytes, which declares
+// func goscript(r io.Reader, w io.Writer) error, copying in through
+// it to out. The data isn't held in memory, so it can be any size:
+//
+//	script := goscript.New(`
+//	import "bufio"
+//	import "io"
+//	import "strings"
+//
+//	func goscript(r io.Reader, w io.Writer) error {
+//		s := bufio.NewScanner(r)
+//		for s.Scan() {
+//			if _, err := io.WriteString(w, strings.ToUpper(s.Text())+"\n"); err != nil {
+//				return err
+//			}
+//		}
+//		return s.Err()
+//	}
+//	`)
+//	err := script.Pipe(os.Stdin, os.Stdout)
+//
+// Each call runs the script in a new process, given the script's
+// stdin and stdout, which Pipe returns from once the script returns.
+// If the script returns an error, or exits, the Error is returned with
+// what it printed to stderr. Filter scripts can't be called with
+// Execute.
+func (s *Script) Pipe(in io.Reader, out io.Writer) error {
+	s.ensureStarted()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.err != nil {
+		return s.err
+	}
+	if s.closed {
+		return errors.New("goscript: the script is closed")
+	}
+	p := s.proc
+	if !p.pipe {
+		return errors.New("goscript: func " + s.opts.entryName() + " must be func(io.Reader, io.Writer) error to be used with Pipe")
+	}
+	// the program is run as the process was, with an argument
+	// telling it to filter
+	cmd := exec.Command(p.cmd.Path, append(p.cmd.Args[1:], pipeArg)...)
+	if err := setupCmd(cmd, s.opts); err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = in, out, &stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if len(s.opts.cpuAffinity) > 0 {
+		if err := setCPUAffinity(cmd.Process.Pid, s.opts.cpuAffinity); err != nil {
+			killProcess(cmd)
+			cmd.Wait()
+			return err
+		}
+	}
+	err := cmd.Wait()
+	// as with Close, nothing the filter started is left running
+	killProcess(cmd)
+	if err == nil {
+		return nil
+	}
+	return p.pipeErr(err, stderr.Bytes())
+}
+
+// pipeArg is the argument that tells the program to run the script's
+// filter, for Pipe.
+const pipeArg = "-goscript-pipe"
+
+// errPipeScript is returned by calls to func goscript in scripts that
+// declare it as a filter.
+var errPipeScript = errors.New("goscript: func goscript is a filter; use Pipe")
+
+// isPipeScript gets whether the entry point declared by the script is a
+// filter, for Pipe.
+func isPipeScript(script, entry string) bool {
+	funcs, _ := parseScript(script, entry)
+	if funcs.goscript == nil || funcs.goscript.results != 1 {
+		return false
+	}
+	params := funcs.goscript.params
+	return len(params) == 2 && params[0].Typ == "io.Reader" && params[1].Typ == "io.Writer"
+}
+
+// pipeErr gets the Error for a filter run by Pipe that failed with
+// err, having printed stderr.
+func (p *process) pipeErr(err error, stderr []byte) error {
+	pipeErr := Error{
+		Err:    err,
+		Stderr: p.redact(processOutput(stderr)),
+		format: p.formatError,
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return pipeErr
+	}
+	pipeErr.ExitCode, pipeErr.exited = exitErr.ExitCode(), true
+	if p.program == nil {
+		// go run reports the script's exit status, and exits with
+		// its own
+		if m := goRunExitRegexp.FindStringSubmatch(pipeErr.Stderr); m != nil {
+			pipeErr.Stderr = pipeErr.Stderr[:len(pipeErr.Stderr)-len(m[0])]
+			pipeErr.ExitCode, _ = strconv.Atoi(m[1])
+		}
+	}
+	return pipeErr
+}
@@ -0,0 +1,77 @@
+package goscript
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+const upperScript = `
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+func goscript(r io.Reader, w io.Writer) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if s.Text() == "fail" {
+			return errors.New("told to fail")
+		}
+		if _, err := io.WriteString(w, strings.ToUpper(s.Text())+"\n"); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+`
+
+func TestPipe(t *testing.T) {
+	for _, mode := range []ExecMode{GoRun, GoBuild} {
+		is := is.New(t)
+		script := New(upperScript, WithExecMode(mode))
+		defer script.Close()
+
+		in := strings.Repeat("the quick brown fox\n", 100000)
+		var out bytes.Buffer
+		err := script.Pipe(strings.NewReader(in), &out)
+		is.NoErr(err)                               // Pipe
+		is.Equal(out.String(), strings.ToUpper(in)) // filtered
+
+		// each call runs the filter again
+		out.Reset()
+		err = script.Pipe(strings.NewReader("again\n"), &out)
+		is.NoErr(err) // Pipe again
+		is.Equal(out.String(), "AGAIN\n")
+
+		out.Reset()
+		err = script.Pipe(strings.NewReader("ok\nfail\nnever\n"), &out)
+		var scriptErr Error
+		is.True(errors.As(err, &scriptErr))                         // Error
+		is.Equal(scriptErr.ExitCode, 1)                             // exit code
+		is.True(strings.Contains(scriptErr.Stderr, "told to fail")) // stderr
+		is.Equal(out.String(), "OK\n")
+
+		_, err = script.Execute()
+		is.Equal(err, errPipeScript) // Execute a filter
+	}
+}
+
+func TestPipeNotFilter(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript(s string) (string, error) {
+	return s, nil
+}
+`)
+	defer script.Close()
+	var out bytes.Buffer
+	err := script.Pipe(strings.NewReader("in"), &out)
+	is.True(err != nil) // Pipe to a script that isn't a filter
+	is.True(strings.Contains(err.Error(), "io.Reader"))
+}
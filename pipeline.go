@@ -0,0 +1,127 @@
+package goscript
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+)
+
+// Pipe is a pipeline of scripts, made with Pipeline.
+type Pipe struct {
+	scripts []*Script
+	err     error
+}
+
+// Pipeline composes scripts that each take one argument and return
+// one value, so that the value returned by each script is passed to
+// the next.
+// The scripts are checked up front: each must take one argument, of
+// the type returned by the script before it. If they don't fit
+// together, Execute returns the error.
+// The Pipe doesn't own the scripts; the caller must still close them.
+func Pipeline(scripts ...*Script) *Pipe {
+	p := &Pipe{scripts: scripts}
+	p.err = checkPipeline(scripts)
+	return p
+}
+
+// Execute passes input to the first script, and returns the value
+// returned by the last. It stops at the first script that returns an
+// error.
+func (p *Pipe) Execute(input interface{}) (interface{}, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	v := input
+	for _, s := range p.scripts {
+		var err error
+		if v, err = s.Execute(v); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// checkPipeline checks the scripts' signatures fit together.
+func checkPipeline(scripts []*Script) error {
+	if len(scripts) == 0 {
+		return errors.New("goscript: pipeline has no scripts")
+	}
+	var prev string
+	for i, s := range scripts {
+		in, out, err := s.signature()
+		if err != nil {
+			return fmt.Errorf("goscript: pipeline script %d: %s", i, err)
+		}
+		if i > 0 && !pipeTypesMatch(prev, in) {
+			return fmt.Errorf("goscript: pipeline script %d takes %s, but script %d returns %s", i, in, i-1, prev)
+		}
+		prev = out
+	}
+	return nil
+}
+
+// pipeTypesMatch reports whether a value of type out can be passed
+// for a parameter of type in. Interface types can't be checked until
+// the values are known.
+func pipeTypesMatch(out, in string) bool {
+	return out == in || isInterfaceType(out) || isInterfaceType(in)
+}
+
+func isInterfaceType(typ string) bool {
+	return typ == "interface{}" || typ == "any"
+}
+
+// signature gets the types of the single argument taken, and the
+// value returned, by the script's goscript function.
+func (s *Script) signature() (in, out string, err error) {
+	s.ensureStarted()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.err != nil {
+		return "", "", s.err
+	}
+	var params []arg
+	for _, param := range s.proc.params {
+		if !param.Provided() {
+			params = append(params, param)
+		}
+	}
+	if len(params) != 1 || params[0].Variadic() {
+		return "", "", errors.New("goscript function must take one argument")
+	}
+	if s.opts.stdoutResult {
+		return params[0].Typ, "string", nil
+	}
+	out, err = resultType(s.proc.script, s.opts.entryName())
+	if err != nil {
+		return "", "", err
+	}
+	return params[0].Typ, substituteTypeParams(out, s.opts.typeArgs), nil
+}
+
+// resultType gets the type of the value returned by the function
+// named entry, usually func goscript, which must return one value
+// and an error.
+func resultType(script, entry string) (string, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), "goscript.go", "package main\n"+script, 0)
+	if err != nil {
+		return "", err
+	}
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || fn.Name.Name != entry {
+			continue
+		}
+		// functions that return only an error, or several values,
+		// have no one value to pass on
+		if fn.Type.Results.NumFields() != 2 {
+			return "", errors.New("goscript function must return one value and an error")
+		}
+		return types.ExprString(fn.Type.Results.List[0].Type), nil
+	}
+	return "", fmt.Errorf("missing func %s", entry)
+}
@@ -0,0 +1,67 @@
+package goscript
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestPipeline(t *testing.T) {
+	is := is.New(t)
+	trim := New(`
+import "strings"
+
+func goscript(s string) (string, error) {
+	return strings.TrimSpace(s), nil
+}
+`)
+	defer trim.Close()
+	upper := New(`
+import "strings"
+
+func goscript(s string) (string, error) {
+	return strings.ToUpper(s), nil
+}
+`)
+	defer upper.Close()
+	length := New(`
+func goscript(s string) (int, error) {
+	return len(s), nil
+}
+`)
+	defer length.Close()
+
+	out, err := Pipeline(trim, upper).Execute("  hello  ")
+	is.NoErr(err) // Execute
+	is.Equal(out, "HELLO")
+
+	out, err = Pipeline(trim, length).Execute("  hello  ")
+	is.NoErr(err) // Execute
+	is.Equal(out, 5)
+
+	_, err = Pipeline(length, upper).Execute("hello")
+	is.True(err != nil)
+	is.Equal(err.Error(), "goscript: pipeline script 1 takes string, but script 0 returns int")
+
+	// scripts must return one value to pass on
+	for _, results := range []string{"error", "(int, string, error)", "(a, b int, err error)"} {
+		multi := New(`
+func goscript(s string) ` + results + ` {
+	panic("not called")
+}
+`)
+		_, err = Pipeline(trim, multi).Execute("hello")
+		multi.Close()
+		is.True(err != nil)
+		is.Equal(err.Error(), "goscript: pipeline script 1: goscript function must return one value and an error")
+	}
+	named := New(`
+func goscript(s string) (n int, err error) {
+	return len(s), nil
+}
+`)
+	defer named.Close()
+	out, err = Pipeline(trim, named).Execute("  hello  ")
+	is.NoErr(err) // Execute
+	is.Equal(out, 5)
+}
@@ -0,0 +1,120 @@
+package goscript
+
+import "sync"
+
+// Pool runs a script in several processes, so that calls can be made
+// to it concurrently.
+type Pool struct {
+	prog    *Program
+	scripts []*Script
+	// idle holds the scripts not running a call.
+	idle chan *Script
+}
+
+// NewPool compiles the script, and starts n processes running it.
+// Caller must call Close.
+func NewPool(script string, n int, opts ...Option) (*Pool, error) {
+	prog, err := Compile(script, opts...)
+	if err != nil {
+		return nil, err
+	}
+	p := &Pool{prog: prog, idle: make(chan *Script, n)}
+	for i := 0; i < n; i++ {
+		s := prog.New()
+		p.scripts = append(p.scripts, s)
+		if s.err != nil {
+			p.Close()
+			return nil, s.err
+		}
+		p.idle <- s
+	}
+	return p, nil
+}
+
+// Execute executes the script with the specified arguments in an idle
+// process, waiting for one if they are all busy.
+func (p *Pool) Execute(args ...interface{}) (interface{}, error) {
+	s := <-p.idle
+	defer func() { p.idle <- s }()
+	return s.Execute(args...)
+}
+
+// Result is the outcome of a call made by Map.
+type Result struct {
+	// Args holds the arguments the call was made with.
+	Args  []interface{}
+	Value interface{}
+	Err   error
+}
+
+// mapAttempts is how many times Map makes a call whose process
+// crashes before giving up on it.
+const mapAttempts = 3
+
+// mapJob is a call to be made by Map.
+type mapJob struct {
+	args     []interface{}
+	attempts int
+}
+
+// Map executes the script with each set of arguments received from
+// inputs, spread across the pool's processes, and sends the results
+// to the returned channel, which is closed once inputs has been
+// closed and every call has finished.
+// Results are sent as calls finish, so they are not in the order of
+// inputs, but each holds the arguments it is for.
+// If a process crashes during a call, it is restarted, and the call
+// is queued again, up to three times in all, after which its Result
+// holds the error.
+func (p *Pool) Map(inputs <-chan []interface{}) <-chan Result {
+	results := make(chan Result)
+	jobs := make(chan mapJob)
+	var pending sync.WaitGroup
+	go func() {
+		for args := range inputs {
+			pending.Add(1)
+			jobs <- mapJob{args: args}
+		}
+		pending.Wait()
+		close(jobs)
+	}()
+	var workers sync.WaitGroup
+	for range p.scripts {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range jobs {
+				s := <-p.idle
+				v, err := s.Execute(job.args...)
+				crashed := err != nil && s.restartIfExited()
+				p.idle <- s
+				job.attempts++
+				if crashed && job.attempts < mapAttempts {
+					go func(job mapJob) { jobs <- job }(job)
+					continue
+				}
+				results <- Result{Args: job.args, Value: v, Err: err}
+				pending.Done()
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// Close closes the pool's processes, and removes the compiled
+// program. Calls must have finished, and the channels returned by
+// Map been drained.
+func (p *Pool) Close() error {
+	var err error
+	for _, s := range p.scripts {
+		if closeErr := s.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	p.prog.Close()
+	return err
+}
@@ -0,0 +1,108 @@
+package goscript
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestPoolMap(t *testing.T) {
+	is := is.New(t)
+	pool, err := NewPool(`
+import "syscall"
+
+// goscript doubles n, but crashes the first time it is given a
+// marker file, which it creates.
+func goscript(n int, marker string) (int, error) {
+	if marker != "" {
+		fd, err := syscall.Open(marker, syscall.O_CREAT|syscall.O_EXCL|syscall.O_WRONLY, 0600)
+		if err == nil {
+			syscall.Close(fd)
+			syscall.Exit(1)
+		}
+	}
+	return n * 2, nil
+}
+`, 4)
+	is.NoErr(err) // NewPool
+	defer pool.Close()
+	marker := filepath.Join(t.TempDir(), "crashed")
+	inputs := make(chan []interface{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			m := ""
+			if i == 500 {
+				m = marker
+			}
+			inputs <- []interface{}{i, m}
+		}
+		close(inputs)
+	}()
+	seen := make(map[int]bool)
+	for res := range pool.Map(inputs) {
+		is.NoErr(res.Err) // Map
+		n := res.Args[0].(int)
+		is.Equal(res.Value, n*2)
+		seen[n] = true
+	}
+	is.Equal(len(seen), 1000)
+	_, err = os.Stat(marker)
+	is.NoErr(err) // a process crashed
+	v, err := pool.Execute(21, "")
+	is.NoErr(err) // Execute
+	is.Equal(v, 42)
+}
+
+func TestPoolExecuteConcurrent(t *testing.T) {
+	is := is.New(t)
+	// each call waits at a barrier until all of them have arrived,
+	// which they only can if they run at the same time
+	pool, err := NewPool(`
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func goscript(dir string, n int) (int, error) {
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprint(n)), nil, 0600); err != nil {
+		return 0, err
+	}
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		arrived, err := os.ReadDir(dir)
+		if err != nil {
+			return 0, err
+		}
+		if len(arrived) == 4 {
+			return os.Getpid(), nil
+		}
+	}
+	return 0, errors.New("calls didn't overlap")
+}
+`, 4)
+	is.NoErr(err) // NewPool
+	defer pool.Close()
+	dir := t.TempDir()
+	type result struct {
+		pid interface{}
+		err error
+	}
+	results := make(chan result, 4)
+	for i := 0; i < 4; i++ {
+		go func(n int) {
+			pid, err := pool.Execute(dir, n)
+			results <- result{pid, err}
+		}(i)
+	}
+	pids := make(map[interface{}]bool)
+	for i := 0; i < 4; i++ {
+		res := <-results
+		is.NoErr(res.err) // Execute
+		pids[res.pid] = true
+	}
+	is.Equal(len(pids), 4) // each call ran in its own process
+}
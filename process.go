@@ -0,0 +1,912 @@
+package goscript
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// process is a running script subprocess.
+type process struct {
+	script string
+	// program is the compiled program being run, or nil if the
+	// script is run with go run.
+	program *Program
+	// scriptFiles are the generated file and any extra source
+	// files, when the script is run with go run, and scriptDir is
+	// the temporary directory they are in.
+	scriptFiles []string
+	scriptDir   string
+	// source is the generated program.
+	source []byte
+	params []arg
+	// vetWarnings holds the problems go vet found, for WithVet.
+	vetWarnings []string
+	// entries holds the script's other entry points,
+	// noGoscript is set if it only has those, pipe is set if
+	// func goscript is a filter for Pipe, and named is set if it
+	// takes a struct of parameters for ExecuteNamed.
+	entries    []entryPoint
+	noGoscript bool
+	pipe       bool
+	named      bool
+	cmd        *exec.Cmd
+	onCrash    func(err error, stderr string)
+	// onLeak is called with the growth in goroutines after calls
+	// that leave more than leakThreshold behind.
+	onLeak        func(growth int)
+	leakThreshold int
+	// formatError is set on the Errors the process returns.
+	formatError func(Error) string
+	// observer is told when the process starts and closes.
+	observer Observer
+	// limits is set if the process has resource limits.
+	limits *ResourceLimits
+	// redact masks secrets in the process's output.
+	redact func(string) string
+
+	// autoRegister is whether argument types are registered as they
+	// are seen, and registered holds the names already registered.
+	autoRegister bool
+	registered   map[string]bool
+	// coerceArgs is whether string arguments are parsed into the
+	// types of the goscript parameters.
+	coerceArgs bool
+	// strictArgs is whether argument types are checked against
+	// the goscript parameters.
+	strictArgs  bool
+	codec       Codec
+	compression Compression
+
+	executeLock sync.Mutex
+	progress    int64 // accessed atomically
+	// streamed counts the chunks and yielded values received in
+	// the current call, which are acknowledged every streamWindow.
+	// Guarded by executeLock.
+	streamed int
+	// executions counts the calls made, and restarting is set
+	// once a restart has begun; both are accessed atomically.
+	executions int64
+	restarting int32
+
+	stdin        io.WriteCloser
+	stdinencoder encoder
+	stdout       io.ReadCloser
+	stdoutbuf    *bufio.Reader
+	stderr       io.ReadCloser
+	// unfeedStdin stops giving the process the input set by
+	// WithStdin.
+	unfeedStdin func()
+
+	// responses receives the responses read from stdout by
+	// readResponses, and is closed with readErr set when reading
+	// fails.
+	responses chan response
+	readErr   error
+	// readFailed is set, atomically, once reading responses has
+	// failed.
+	readFailed int32
+	// killed is set, atomically, once the process has been killed
+	// because a call was cancelled.
+	killed int32
+	// scriptStarted is set to 1 once the script program has
+	// started running, after it has been compiled by go run.
+	scriptStarted int32
+
+	// started is closed once start has finished, successfully
+	// or not.
+	started chan struct{}
+	// done is closed once the process has exited, after which
+	// waitErr and stderrOut are set.
+	done      chan struct{}
+	waitErr   error
+	stderrOut []byte
+	// stderrBuf collects stderr as the process writes it, for
+	// Script.Stderr, keeping the last maxStderr bytes.
+	stderrBuf lockedBuffer
+
+	// closeOnce makes close only shut the process down once, and
+	// closeErr is what it returns.
+	closeOnce sync.Once
+	closeErr  error
+
+	lock    sync.Mutex // guards ready, closing and output
+	ready   bool
+	closing bool
+	// output receives what the script writes to os.Stdout, if
+	// it is set.
+	output io.Writer
+}
+
+func newProcess(script string, opts options) *process {
+	// errors finding entry points are reported when the script is
+	// generated
+	entries, _ := entryPoints(script, opts.entryName())
+	return &process{
+		entries:       entries,
+		noGoscript:    !declaresGoscript(script, opts.entryName()),
+		pipe:          isPipeScript(script, opts.entryName()),
+		named:         namedParam(script, opts.entryName()) != "",
+		script:        script,
+		onCrash:       opts.onCrash,
+		onLeak:        opts.onLeak,
+		leakThreshold: opts.leakThreshold,
+		formatError:   opts.formatError,
+		observer:      opts.observer,
+		limits:        opts.limits,
+		redact:        opts.redactor(),
+		autoRegister:  opts.autoRegister,
+		coerceArgs:    opts.coerceArgs,
+		strictArgs:    opts.strictArgs,
+		codec:         opts.codec,
+		compression:   opts.compression,
+		registered:    make(map[string]bool),
+		stderrBuf:     lockedBuffer{limit: maxStderr},
+		started:       make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+// startProcess generates the script and starts it with go run, and
+// waits for it to be ready.
+// If an error is returned, any resources will have been cleaned up.
+func startProcess(script string, opts options) (*process, error) {
+	if scriptHarnessTemplateErr != nil {
+		return nil, scriptHarnessTemplateErr
+	}
+	start := time.Now()
+	p := newProcess(script, opts)
+	err := p.start(script, opts)
+	p.observeStart(start, err)
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// startProgramProcess starts a compiled program, and waits for it to
+// be ready.
+// If an error is returned, any resources will have been cleaned up.
+func startProgramProcess(prog *Program) (*process, error) {
+	start := time.Now()
+	p := newProcess(prog.script, prog.opts)
+	p.program = prog
+	p.params = prog.params
+	p.source = prog.source
+	p.vetWarnings = prog.vetWarnings
+	err := p.launch(exec.Command(prog.binary), prog.opts)
+	p.observeStart(start, err)
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *process) start(script string, opts options) error {
+	var err error
+	if p.params, err = processScript(script, opts.entryName(), opts.typeArgs); err != nil {
+		return err
+	}
+	var src bytes.Buffer
+	if err = generateScript(&src, script, p.params, opts); err != nil {
+		return err
+	}
+	p.source = src.Bytes()
+	if opts.vet {
+		if p.vetWarnings, err = vetScript(p.source, opts); err != nil {
+			return err
+		}
+	}
+	if p.scriptDir, p.scriptFiles, err = writeScriptFile(p.source, opts); err != nil {
+		return err
+	}
+	args := append([]string{"run"}, opts.buildFlags()...)
+	return p.launch(exec.Command(opts.goCommand(), append(args, p.scriptFiles...)...), opts)
+}
+
+// launch starts the command and waits for the script to be ready.
+func (p *process) launch(cmd *exec.Cmd, opts options) error {
+	defer close(p.started)
+	var err error
+	p.cmd = cmd
+	if err = setupCmd(p.cmd, opts); err != nil {
+		return err
+	}
+	// the script has its own copies of childFiles once it has
+	// started
+	var childFiles []*os.File
+	defer func() {
+		for _, f := range childFiles {
+			f.Close()
+		}
+	}()
+	if opts.stdin != nil {
+		var files []*os.File
+		if p.stdin, files, p.unfeedStdin, err = opts.stdin.pipe(p.cmd); err != nil {
+			return err
+		}
+		childFiles = append(childFiles, files...)
+	} else if p.stdin, err = p.cmd.StdinPipe(); err != nil {
+		return err
+	}
+	p.stdinencoder = newStreamEncoder(opts.codec, opts.compression, p.stdin)
+	if opts.stdoutPipe() {
+		var files []*os.File
+		if p.stdout, files, err = pipeResponses(p.cmd); err != nil {
+			return err
+		}
+		childFiles = append(childFiles, files...)
+	} else if p.stdout, err = p.cmd.StdoutPipe(); err != nil {
+		return err
+	}
+	p.stdoutbuf = bufio.NewReader(p.stdout)
+	if p.stderr, err = p.cmd.StderrPipe(); err != nil {
+		return err
+	}
+	if err = p.cmd.Start(); err != nil {
+		return err
+	}
+	go p.wait()
+	if len(opts.cpuAffinity) > 0 {
+		if err = setCPUAffinity(p.cmd.Process.Pid, opts.cpuAffinity); err != nil {
+			return err
+		}
+	}
+	if err = p.handshake(opts.startLimit()); err != nil {
+		return err
+	}
+	p.responses = make(chan response, responseBuffer)
+	go p.readResponses()
+	p.lock.Lock()
+	p.ready = true
+	p.lock.Unlock()
+	return nil
+}
+
+// pipeResponses gives cmd a pipe after its other extra files to write
+// responses to, whose read end is returned, and makes its stdout a
+// pipe whose read end it is given too, after that, so it can read
+// back what it writes for WithStdoutResult. The files given to cmd
+// are returned as well, to be closed once it has started.
+func pipeResponses(cmd *exec.Cmd) (io.ReadCloser, []*os.File, error) {
+	responses, responsesw, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	stdout, stdoutw, err := os.Pipe()
+	if err != nil {
+		responses.Close()
+		responsesw.Close()
+		return nil, nil, err
+	}
+	cmd.Stdout = stdoutw
+	n := len(cmd.ExtraFiles)
+	cmd.ExtraFiles = append(cmd.ExtraFiles[:n:n], responsesw, stdout)
+	return responses, []*os.File{responsesw, stdout, stdoutw}, nil
+}
+
+// setupCmd sets up the command to run the script as the options
+// say.
+func setupCmd(cmd *exec.Cmd, opts options) error {
+	cmd.ExtraFiles = opts.extraFiles
+	cmd.Env = opts.env()
+	cmd.Dir = opts.workDir
+	if opts.credential != nil {
+		if err := setCredential(cmd, *opts.credential); err != nil {
+			return err
+		}
+	}
+	setProcessGroup(cmd)
+	return nil
+}
+
+// handshake waits for the script process to say it is starting, and
+// then that it is ready, giving up after timeout if it is non-zero.
+func (p *process) handshake(timeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		stray, err := readMarker(p.stdoutbuf, startingMarker)
+		if err == nil {
+			atomic.StoreInt32(&p.scriptStarted, 1)
+			var more []byte
+			more, err = readMarker(p.stdoutbuf, p.compression.readyMarker())
+			stray = append(stray, more...)
+		}
+		if err != nil {
+			<-p.done
+			if exitErr := p.exitErr(); exitErr != nil {
+				errs <- exitErr
+				return
+			}
+			errs <- errors.New("goscript failed to start")
+			return
+		}
+		if len(stray) > 0 {
+			errs <- fmt.Errorf("goscript: unexpected output before the script was ready: %q", p.redact(string(stray)))
+			return
+		}
+		errs <- nil
+	}()
+	var timeoutc <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timeoutc = t.C
+	}
+	select {
+	case err := <-errs:
+		return err
+	case <-timeoutc:
+		if atomic.LoadInt32(&p.scriptStarted) == 0 {
+			return fmt.Errorf("%w: the script didn't start within %s", ErrStartTimeout, timeout)
+		}
+		return fmt.Errorf("%w: the script started, but its initialization didn't finish within %s", ErrStartTimeout, timeout)
+	}
+}
+
+// maxStrayOutput limits how much stray output readMarker collects.
+const maxStrayOutput = 4096
+
+// readMarker reads up to and including the marker, and returns
+// anything that came before it.
+func readMarker(r *bufio.Reader, marker string) ([]byte, error) {
+	var stray []byte
+	for {
+		line, err := r.ReadBytes('\n')
+		if bytes.HasSuffix(line, []byte(marker)) {
+			stray = append(stray, line[:len(line)-len(marker)]...)
+			return stray, nil
+		}
+		if err != nil {
+			return stray, err
+		}
+		if len(stray) < maxStrayOutput {
+			stray = append(stray, line...)
+		}
+	}
+}
+
+// wait collects stderr and waits for the process to exit.
+// If the process exits unexpectedly after becoming ready, the
+// onCrash callback is called.
+func (p *process) wait() {
+	io.Copy(&p.stderrBuf, p.stderr)
+	p.stderrOut = p.stderrBuf.Bytes()
+	p.waitErr = p.cmd.Wait()
+	close(p.done)
+	<-p.started
+	p.lock.Lock()
+	crashed := p.ready && !p.closing
+	p.lock.Unlock()
+	if crashed && p.onCrash != nil {
+		p.onCrash(p.waitErr, p.redact(processOutput(p.stderrOut)))
+	}
+}
+
+// maxStderr limits how much of a process's stderr is kept, so that
+// scripts writing to stderr for a long time don't use up memory.
+const maxStderr = 1 << 20
+
+// lockedBuffer is a bytes.Buffer that is safe for concurrent use.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	// limit, if set, is how many of the most recent bytes written
+	// are kept.
+	limit int
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n, err := b.buf.Write(p)
+	if b.limit > 0 && b.buf.Len() > 2*b.limit {
+		// drop old bytes in large steps, so they aren't moved on
+		// every write
+		b.buf.Next(b.buf.Len() - b.limit)
+	}
+	return n, err
+}
+
+// Bytes gets a copy of what has been written, or of the last limit
+// bytes, from the start of a line, if it is set.
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data := b.buf.Bytes()
+	if b.limit > 0 && len(data) > b.limit {
+		data = data[len(data)-b.limit:]
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+	return append([]byte(nil), data...)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// execute makes a call to the goscript function, first registering
+// any types the process hasn't seen.
+// The error is only for failures to make the call; errors returned
+// by the script are in the response.
+func (p *process) execute(ctx context.Context, req request, types []typeRegistration) (response, error) {
+	res, err := p.begin(ctx, req, types)
+	if err != nil {
+		return res, err
+	}
+	p.executeLock.Unlock()
+	return res, nil
+}
+
+// begin is like execute, but leaves executeLock held if it succeeds,
+// so that the caller can go on to receive more of the response.
+func (p *process) begin(ctx context.Context, req request, types []typeRegistration) (response, error) {
+	if req.Func == "" && p.noGoscript {
+		return response{}, errNoGoscript
+	}
+	if req.Func == "" && p.pipe {
+		return response{}, errPipeScript
+	}
+	var args []interface{}
+	switch {
+	case req.Named:
+		if !p.named {
+			return response{}, errNotNamed
+		}
+		// the script checks the values as it sets the fields
+		args = namedValues(req.Args)
+	case req.Batch == nil:
+		var err error
+		if req.Args, err = p.prepareArgs(req.Func, req.Args); err != nil {
+			return response{}, err
+		}
+		args = req.Args
+	}
+	// batched args have been prepared by ExecuteBatch
+	for _, batchArgs := range req.Batch {
+		args = append(args, batchArgs...)
+	}
+	p.executeLock.Lock()
+	if p.poisoned() {
+		p.executeLock.Unlock()
+		return response{}, ErrPoisoned
+	}
+	if err := p.registerTypes(ctx, types, args); err != nil {
+		p.executeLock.Unlock()
+		return response{}, err
+	}
+	atomic.StoreInt64(&p.progress, 0)
+	p.streamed = 0
+	res, err := p.roundTrip(ctx, req)
+	if err != nil {
+		p.executeLock.Unlock()
+		return res, err
+	}
+	return res, nil
+}
+
+// prepareArgs coerces and checks args for a call to the entry point
+// with the name, or to func goscript if name is empty, as set up by
+// the options.
+func (p *process) prepareArgs(name string, args []interface{}) ([]interface{}, error) {
+	if len(args) == 0 {
+		args = []interface{}{}
+	}
+	params, ok := p.entryParams(name)
+	if p.coerceArgs {
+		var err error
+		if args, err = coerceArgs(params, args); err != nil {
+			return nil, err
+		}
+	}
+	if ok {
+		if err := checkArgCount(params, args); err != nil {
+			return nil, err
+		}
+	}
+	if p.strictArgs {
+		if err := checkArgs(params, args); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+// ping sends a ping and waits for the answer.
+func (p *process) ping(ctx context.Context) error {
+	p.executeLock.Lock()
+	defer p.executeLock.Unlock()
+	if p.poisoned() {
+		return ErrPoisoned
+	}
+	_, err := p.roundTrip(ctx, request{Ping: true})
+	return err
+}
+
+// roundTrip sends a request and waits for the response.
+// Caller must hold executeLock.
+func (p *process) roundTrip(ctx context.Context, req request) (response, error) {
+	if err := p.send(req); err != nil {
+		return response{}, p.cmdErr(err)
+	}
+	return p.receive(ctx)
+}
+
+// argChunkSize is the most variadic arguments sent in one frame.
+const argChunkSize = 1024
+
+// send sends the request. Long lists of variadic arguments are split
+// across frames, each with More set if another follows, so they are
+// never all encoded at once.
+// Caller must hold executeLock.
+func (p *process) send(req request) error {
+	start := -1
+	params, _ := p.entryParams(req.Func)
+	for _, param := range params {
+		if param.Variadic() {
+			start = param.ArgIndex
+		}
+	}
+	if start < 0 || len(req.Args)-start <= argChunkSize {
+		return p.stdinencoder.Encode(req)
+	}
+	args := req.Args
+	req.Args, req.More = args[:start+argChunkSize], true
+	if err := p.stdinencoder.Encode(req); err != nil {
+		return err
+	}
+	for i := start + argChunkSize; i < len(args); i += argChunkSize {
+		end := i + argChunkSize
+		if end > len(args) {
+			end = len(args)
+		}
+		if err := p.stdinencoder.Encode(request{Args: args[i:end], More: end < len(args)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// responseBuffer is how many responses readResponses reads ahead of
+// the caller.
+const responseBuffer = 64
+
+// streamWindow is how many chunks of a streamed result, or values
+// yielded to ExecuteStream, the script sends before it waits for the
+// caller to receive them, which keeps streams within responseBuffer.
+const streamWindow = responseBuffer / 2
+
+// ErrResponseOverflow is returned by calls to a script that sent more
+// responses than goscript buffers without waiting for the caller.
+// The script is killed, since its responses can't be read in order.
+var ErrResponseOverflow = fmt.Errorf("goscript: the script sent more than %d responses ahead of the caller", responseBuffer)
+
+// readResponses reads responses from the script process as soon as
+// they are written, so the script doesn't block on a full pipe while
+// the caller is busy, until reading fails.
+// Progress updates are recorded as they arrive, and don't count
+// towards responseBuffer, so a script can report progress as often
+// as it likes. Streamed chunks and values wait for the caller every
+// streamWindow, so only a broken script fills the buffer, in which
+// case reading fails with ErrResponseOverflow, rather than blocking
+// until the caller catches up.
+func (p *process) readResponses() {
+	defer close(p.responses)
+	defer atomic.StoreInt32(&p.readFailed, 1)
+	dec, err := newStreamDecoder(p.codec, p.compression, p.stdoutbuf)
+	if err != nil {
+		p.readErr = p.cmdErr(err)
+		return
+	}
+	for {
+		var res response
+		if err := dec.Decode(&res); err != nil {
+			p.readErr = p.cmdErr(err)
+			return
+		}
+		if res.IsProgress {
+			atomic.StoreInt64(&p.progress, int64(res.Progress))
+			continue
+		}
+		if res.IsOutput {
+			p.writeOutput(res.Output)
+			continue
+		}
+		select {
+		case p.responses <- res:
+		default:
+			p.readErr = ErrResponseOverflow
+			if p.cmd != nil && p.cmd.Process != nil {
+				killProcess(p.cmd)
+			}
+			return
+		}
+	}
+}
+
+// next waits for the next response, unless ctx is done first, in
+// which case the process is killed. Scripts that are given the
+// deadline have deadlineGrace to return after it passes.
+func (p *process) next(ctx context.Context) (response, bool, error) {
+	select {
+	case res, ok := <-p.responses:
+		return res, ok, nil
+	case <-ctx.Done():
+	}
+	if ctx.Err() == context.DeadlineExceeded && p.takesDeadline() {
+		t := time.NewTimer(deadlineGrace)
+		defer t.Stop()
+		select {
+		case res, ok := <-p.responses:
+			return res, ok, nil
+		case <-t.C:
+		}
+	}
+	p.kill()
+	return response{}, false, ctx.Err()
+}
+
+// takesDeadline reports whether the goscript function is given the
+// deadline of calls, through a context or deadline parameter.
+func (p *process) takesDeadline() bool {
+	for _, param := range p.params {
+		if param.Context() || param.Deadline() {
+			return true
+		}
+	}
+	return false
+}
+
+// deadlineGrace is how long scripts that are given the context's
+// deadline have to return once it has passed, before they are killed.
+const deadlineGrace = 100 * time.Millisecond
+
+// setOutput sets the writer that receives the script's output.
+func (p *process) setOutput(w io.Writer) {
+	p.lock.Lock()
+	p.output = w
+	p.lock.Unlock()
+}
+
+// writeOutput writes output from the script to the output writer, if
+// there is one.
+func (p *process) writeOutput(b []byte) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.output != nil {
+		p.output.Write(b)
+	}
+}
+
+// receive gets the next response. If ctx is done first, the process
+// is killed, since the response can't be skipped.
+// Caller must hold executeLock.
+func (p *process) receive(ctx context.Context) (response, error) {
+	res, ok, err := p.next(ctx)
+	if err != nil {
+		return response{}, err
+	}
+	if !ok {
+		return response{}, p.readErr
+	}
+	if res.IsChunk || res.IsYield {
+		p.streamed++
+		if p.streamed%streamWindow == 0 {
+			// let the script send the next window
+			if err := p.stdinencoder.Encode(request{Ack: true}); err != nil {
+				return response{}, p.cmdErr(err)
+			}
+		}
+	}
+	if p.onLeak != nil && res.Goroutines > p.leakThreshold {
+		p.onLeak(res.Goroutines)
+	}
+	if res.Err != "" {
+		return res, errors.New(res.Err)
+	}
+	if res.ErrorText != "" {
+		res.Error = errors.New(res.ErrorText)
+	}
+	return res, nil
+}
+
+// registerTypes makes sure the script process has registered the
+// types, along with the named struct types of args if autoRegister
+// is set.
+// Caller must hold executeLock.
+func (p *process) registerTypes(ctx context.Context, types []typeRegistration, args []interface{}) error {
+	if p.codec == JSONCodec {
+		// types are only registered for gob
+		return nil
+	}
+	if p.autoRegister {
+		for _, a := range args {
+			reg, ok, err := registerType(a)
+			if err != nil {
+				return err
+			}
+			if ok {
+				types = append(types, reg)
+			}
+		}
+	}
+	var regs []typeRegistration
+	for _, reg := range types {
+		if !p.registered[reg.Name] {
+			regs = append(regs, reg)
+		}
+	}
+	if len(regs) == 0 {
+		return nil
+	}
+	if _, err := p.roundTrip(ctx, request{Register: regs}); err != nil {
+		return err
+	}
+	for _, reg := range regs {
+		p.registered[reg.Name] = true
+	}
+	return nil
+}
+
+// kill kills the process without waiting for it to exit, for calls
+// that are cancelled. It isn't reported as a crash.
+func (p *process) kill() {
+	atomic.StoreInt32(&p.killed, 1)
+	p.lock.Lock()
+	p.closing = true
+	p.lock.Unlock()
+	p.stdin.Close()
+	if !p.exited() {
+		killProcess(p.cmd)
+	}
+}
+
+// cancelled reports whether the process was killed because a call
+// was cancelled.
+func (p *process) cancelled() bool {
+	return atomic.LoadInt32(&p.killed) == 1
+}
+
+// poisoned reports whether the process can't take any more calls,
+// because it has exited or its responses can no longer be read.
+func (p *process) poisoned() bool {
+	return atomic.LoadInt32(&p.readFailed) == 1 || p.exited()
+}
+
+// exited reports whether the process has exited.
+func (p *process) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// cmdErrWait is how long cmdErr waits for a failing process to exit.
+const cmdErrWait = time.Second
+
+// cmdErr turns a protocol error into an Error if it was caused by the
+// process exiting.
+func (p *process) cmdErr(err error) error {
+	select {
+	case <-p.done:
+	case <-time.After(cmdErrWait):
+		return err
+	}
+	if exitErr := p.exitErr(); exitErr != nil {
+		return exitErr
+	}
+	return err
+}
+
+// exitErr gets an Error describing why the process exited, or nil
+// if it exited cleanly.
+// Caller must wait for done.
+func (p *process) exitErr() error {
+	if p.waitErr == nil {
+		return nil
+	}
+	stderr := p.redact(processOutput(p.stderrOut))
+	if atomic.LoadInt32(&p.scriptStarted) == 0 {
+		// such as when go run fails to compile the script
+		return Error{Err: p.waitErr, Stderr: stderr, format: p.formatError}
+	}
+	err := Error{
+		Err:      p.waitErr,
+		Stderr:   stderr,
+		ExitCode: p.cmd.ProcessState.ExitCode(),
+		exited:   true,
+		format:   p.formatError,
+	}
+	if p.program == nil {
+		// go run reports the script's exit status, and exits with
+		// its own
+		if m := goRunExitRegexp.FindStringSubmatch(stderr); m != nil {
+			err.Stderr = stderr[:len(stderr)-len(m[0])]
+			err.ExitCode, _ = strconv.Atoi(m[1])
+		}
+	}
+	if killedBySIGSYS(p.waitErr, stderr) {
+		err.Err = ErrBlockedSyscall
+	}
+	if exceededLimits(p.limits, p.cmd.ProcessState, stderr) {
+		err.Err = ErrResourceLimit
+	}
+	return err
+}
+
+// goRunExitRegexp matches the exit status of the script reported by
+// go run, at the end of its output.
+var goRunExitRegexp = regexp.MustCompile(`(?:^|\n)exit status (\d+)$`)
+
+// close shuts down the process and removes its files, and returns the
+// Error the process exited with if it had exited by itself. Calling it
+// again returns the same error.
+func (p *process) close() error {
+	p.closeOnce.Do(func() {
+		p.lock.Lock()
+		ready := p.ready
+		p.lock.Unlock()
+		p.closeErr = p.shutdown()
+		if ready && p.observer != nil {
+			p.observer.OnClose(p.closeErr)
+		}
+	})
+	return p.closeErr
+}
+
+// shutdown kills the process if it is still running, waits for it to
+// exit, and removes its files.
+func (p *process) shutdown() error {
+	var err error
+	if p.exited() && !p.cancelled() {
+		// it exited by itself before being closed
+		err = p.exitErr()
+	}
+	p.lock.Lock()
+	p.closing = true
+	p.lock.Unlock()
+	if p.stdin != nil {
+		p.stdin.Close()
+	}
+	if p.unfeedStdin != nil {
+		p.unfeedStdin()
+	}
+	if p.cmd != nil && p.cmd.Process != nil {
+		// even if the script has exited, processes it started may
+		// still be running in its process group
+		killProcess(p.cmd)
+	}
+	if p.stdout != nil {
+		p.stdout.Close()
+	}
+	if p.stderr != nil {
+		p.stderr.Close()
+	}
+	if p.cmd != nil && p.cmd.Process != nil {
+		<-p.done
+	}
+	if p.scriptDir != "" {
+		if rmErr := os.RemoveAll(p.scriptDir); rmErr != nil && err == nil {
+			err = rmErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package goscript
+
+import "os/exec"
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcess kills the command's process. A script started by go run
+// exits once its stdin is closed, but processes the script starts are
+// left running.
+func killProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
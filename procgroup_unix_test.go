@@ -0,0 +1,66 @@
+//go:build unix
+
+package goscript
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestCloseKillsChildren(t *testing.T) {
+	if _, err := os.Stat("/bin/sleep"); err != nil {
+		t.Skip("no /bin/sleep")
+	}
+	is := is.New(t)
+	for _, exit := range []bool{false, true} {
+		script := New(`
+import (
+	"os"
+	"os/exec"
+)
+
+func goscript(exit bool) (int, error) {
+	cmd := exec.Command("/bin/sleep", "60")
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	if exit {
+		os.Exit(1)
+	}
+	return cmd.Process.Pid, nil
+}
+`)
+		pid, err := script.Execute(false)
+		is.NoErr(err) // Execute
+		if exit {
+			// the script exits, leaving its first child running
+			script.Execute(true)
+		}
+		script.Close()
+		is.True(processGone(pid.(int), 5*time.Second))
+	}
+}
+
+// processGone waits for the process to stop running, and reports
+// whether it did within timeout. Zombies count as stopped, since
+// they may be left unreaped where there is no init process.
+func processGone(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if syscall.Kill(pid, 0) != nil {
+			return true
+		}
+		stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+		if err == nil && strings.Contains(string(stat), ") Z ") {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
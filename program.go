@@ -0,0 +1,337 @@
+package goscript
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Program is a compiled script, from which Scripts can be started
+// without compiling again.
+type Program struct {
+	script string
+	opts   options
+	params []arg
+	source []byte
+	dir    string
+	binary string
+	// vetWarnings holds the problems go vet found, for WithVet.
+	vetWarnings []string
+}
+
+// Compile compiles the script into a Program.
+// Caller must call Close once the Program is no longer needed.
+func Compile(script string, opts ...Option) (*Program, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return compile(script, o)
+}
+
+// Validate checks the script compiles, without running it, and
+// returns the error if it doesn't, which is quicker than starting it,
+// such as to check scripts as they are edited. Errors are reported at
+// their lines in the script, as when starting it. Nothing is kept.
+func Validate(script string, opts ...Option) error {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	_, src, err := newProgram(script, o)
+	if err != nil {
+		return err
+	}
+	// go build discards the binary built to the null device
+	return build(src, os.DevNull, o)
+}
+
+func compile(script string, opts options) (*Program, error) {
+	prog, src, err := newProgram(script, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.vet {
+		if prog.vetWarnings, err = vetScript(src, opts); err != nil {
+			return nil, err
+		}
+	}
+	if prog.dir, err = ioutil.TempDir("", "goscript"); err != nil {
+		return nil, err
+	}
+	prog.binary = filepath.Join(prog.dir, "goscript"+exeSuffix)
+	if err := build(src, prog.binary, opts); err != nil {
+		prog.Close()
+		return nil, err
+	}
+	if opts.credential != nil {
+		// let the script's user run the binary
+		if err := os.Chmod(prog.dir, 0755); err != nil {
+			prog.Close()
+			return nil, err
+		}
+	}
+	return prog, nil
+}
+
+// newProgram makes a Program for the script, without a binary, and
+// generates its source.
+func newProgram(script string, opts options) (*Program, []byte, error) {
+	if scriptHarnessTemplateErr != nil {
+		return nil, nil, scriptHarnessTemplateErr
+	}
+	prog := &Program{script: script, opts: opts}
+	var err error
+	if prog.params, err = processScript(script, opts.entryName(), opts.typeArgs); err != nil {
+		return nil, nil, err
+	}
+	var src bytes.Buffer
+	if err := generateScript(&src, script, prog.params, opts); err != nil {
+		return nil, nil, err
+	}
+	prog.source = src.Bytes()
+	return prog, prog.source, nil
+}
+
+// exeSuffix is the suffix of executable files.
+var exeSuffix = func() string {
+	if runtime.GOOS == "windows" {
+		return ".exe"
+	}
+	return ""
+}()
+
+// build compiles the generated source into binary.
+func build(src []byte, binary string, opts options) error {
+	dir, files, err := writeScriptFile(src, opts)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	args := append([]string{"build", "-o", binary}, opts.buildFlags()...)
+	if opts.buildProgress != nil {
+		args = append(args, "-v")
+	}
+	var cmd *exec.Cmd
+	if opts.module != nil {
+		// build the module's package, adding the checksums of its
+		// requirements to go.sum as they are downloaded
+		cmd = exec.Command(opts.goCommand(), append(args, "-mod=mod", ".")...)
+		cmd.Dir = dir
+	} else {
+		cmd = exec.Command(opts.goCommand(), append(args, files...)...)
+	}
+	out, err := runBuild(cmd, opts.buildProgress)
+	if opts.module != nil {
+		// the errors are headed by the package, like those of
+		// command-line-arguments
+		out = bytes.TrimPrefix(out, []byte("# "+opts.module.path+"\n"))
+	}
+	if err != nil {
+		return Error{Err: err, Stderr: opts.redactor()(processOutput(out)), format: opts.formatError}
+	}
+	return nil
+}
+
+// packageLineRegexp matches the package paths listed by go build -v.
+var packageLineRegexp = regexp.MustCompile(`^[\w.~/-]+$`)
+
+// runBuild runs the build command, passing each line of its output
+// to progress if it is set, and returns the output other than the
+// packages listed as they are built.
+func runBuild(cmd *exec.Cmd, progress func(line string)) ([]byte, error) {
+	if progress == nil {
+		return cmd.CombinedOutput()
+	}
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s := bufio.NewScanner(pr)
+		for s.Scan() {
+			line := s.Text()
+			progress(line)
+			if !packageLineRegexp.MatchString(line) {
+				out.WriteString(line + "\n")
+			}
+		}
+		// keep the pipe drained if scanning fails
+		io.Copy(ioutil.Discard, pr)
+	}()
+	err := cmd.Wait()
+	pw.Close()
+	<-done
+	return out.Bytes(), err
+}
+
+// New starts a new running Script from the compiled program.
+// Caller must call Close.
+func (prog *Program) New() *Script {
+	s := &Script{opts: prog.opts}
+	s.proc, s.err = startProgramProcess(prog)
+	return s
+}
+
+// NewFromBinary starts a new running Script from a binary built
+// before, such as on another machine, and found by BinaryPath, rather
+// than compiling the script:
+//
+//	script := goscript.NewFromBinary("/opt/scripts/greet", src)
+//	defer script.Close()
+//
+// The script and the options must be those the binary was built with,
+// which are used to make calls to it. If the binary can't be started,
+// the error is returned by each call, as with New.
+// Close leaves the binary where it is.
+// Caller must call Close.
+func NewFromBinary(path, script string, opts ...Option) *Script {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	prog, _, err := newProgram(script, o)
+	if err != nil {
+		return &Script{opts: o, err: err}
+	}
+	prog.binary = path
+	return prog.New()
+}
+
+// BinaryPath gets the path of the compiled binary running the script,
+// or an empty string if the script is run with go run (see
+// WithExecMode), or failed to start. The binary can be copied, and run
+// with NewFromBinary, to start the script elsewhere without compiling
+// it. Binaries built for GoBuild are removed by Close, so they must be
+// copied before then.
+func (s *Script) BinaryPath() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.proc == nil || s.proc.program == nil {
+		return ""
+	}
+	return s.proc.program.binary
+}
+
+// Close removes the compiled program.
+// Scripts already started from it must be closed first.
+func (prog *Program) Close() error {
+	if prog.dir == "" {
+		// the binary belongs to the cache, or to the caller of
+		// NewFromBinary
+		return nil
+	}
+	return os.RemoveAll(prog.dir)
+}
+
+// CompileErrors holds the errors for scripts that failed to compile,
+// keyed by script name.
+type CompileErrors map[string]error
+
+func (e CompileErrors) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s: %s", name, e[name])
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// PrecompileAll compiles the named scripts concurrently, so the cost
+// of compiling can be paid up front, for example when a server starts.
+// Programs are returned for the scripts that compiled, even if others
+// failed; the error is then a CompileErrors. Callers that can't run
+// without every script can use WithStrictPrecompile instead.
+// Unless the GoBuild ExecMode is used, the scripts are compiled to the
+// cache, as for the Cached ExecMode and Run, so scripts that have been
+// compiled before aren't compiled again, and starting them from the
+// cache later is fast too.
+func PrecompileAll(scripts map[string]string, opts ...Option) (map[string]*Program, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	type result struct {
+		name string
+		prog *Program
+		err  error
+	}
+	names := make(chan string)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				prog, err := precompile(scripts[name], o)
+				results <- result{name: name, prog: prog, err: err}
+			}
+		}()
+	}
+	go func() {
+		for name := range scripts {
+			names <- name
+		}
+		close(names)
+		wg.Wait()
+		close(results)
+	}()
+	progs := make(map[string]*Program)
+	errs := make(CompileErrors)
+	for r := range results {
+		if r.err != nil {
+			errs[r.name] = r.err
+			continue
+		}
+		progs[r.name] = r.prog
+	}
+	if len(errs) > 0 && o.strictPrecompile {
+		for _, prog := range progs {
+			prog.Close()
+		}
+		return nil, errs
+	}
+	if len(errs) > 0 {
+		return progs, errs
+	}
+	return progs, nil
+}
+
+// precompile compiles a script for PrecompileAll, to the cache unless
+// the options need it compiled to a temporary directory.
+func precompile(script string, opts options) (*Program, error) {
+	if opts.execMode() == GoBuild {
+		return compile(script, opts)
+	}
+	return cachedProgram(script, opts)
+}
+
+// WithStrictPrecompile makes PrecompileAll fail as a whole if any of
+// the scripts fails to compile, closing the programs for those that
+// compiled, and returning a nil map with the CompileErrors.
+// Other functions ignore it.
+func WithStrictPrecompile() Option {
+	return func(o *options) {
+		o.strictPrecompile = true
+	}
+}
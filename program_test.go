@@ -0,0 +1,458 @@
+package goscript
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestPrecompileAll(t *testing.T) {
+	is := is.New(t)
+	isolateScriptCache(t)
+	progs, err := PrecompileAll(map[string]string{
+		"hello": `
+func goscript(name string) (string, error) {
+	return "Hello " + name, nil
+}
+`,
+		"double": `
+func goscript(n int) (int, error) {
+	return n * 2, nil
+}
+`,
+		"upper": `
+import "strings"
+
+func goscript(s string) (string, error) {
+	return strings.ToUpper(s), nil
+}
+`,
+	})
+	is.NoErr(err) // PrecompileAll
+	is.Equal(len(progs), 3)
+	for _, prog := range progs {
+		defer prog.Close()
+	}
+	tests := []struct {
+		name string
+		arg  interface{}
+		out  interface{}
+	}{
+		{"hello", "Mat", "Hello Mat"},
+		{"double", 21, 42},
+		{"upper", "goscript", "GOSCRIPT"},
+	}
+	for _, test := range tests {
+		script := progs[test.name].New()
+		defer script.Close()
+		out, err := script.Execute(test.arg)
+		is.NoErr(err) // Execute
+		is.Equal(out, test.out)
+	}
+}
+
+func TestPrecompileAllErrors(t *testing.T) {
+	is := is.New(t)
+	isolateScriptCache(t)
+	progs, err := PrecompileAll(map[string]string{
+		"good": `
+func goscript() (int, error) {
+	return 1, nil
+}
+`,
+		"bad": `
+func goscript() (int, error) {
+	return "one", nil
+}
+`,
+	})
+	for _, prog := range progs {
+		defer prog.Close()
+	}
+	errs, ok := err.(CompileErrors)
+	is.True(ok) // CompileErrors
+	is.Equal(len(errs), 1)
+	is.True(errs["bad"] != nil)
+	is.True(progs["good"] != nil)
+
+	progs, err = PrecompileAll(map[string]string{
+		"good": `
+func goscript() (int, error) {
+	return 1, nil
+}
+`,
+		"bad": `
+func goscript() (int, error) {
+	return "one", nil
+}
+`,
+	}, WithStrictPrecompile())
+	is.True(progs == nil) // no programs when strict
+	errs, ok = err.(CompileErrors)
+	is.True(ok) // CompileErrors
+	is.Equal(len(errs), 1)
+}
+
+func TestPrecompileAllCached(t *testing.T) {
+	is := is.New(t)
+	dir := isolateScriptCache(t)
+	scripts := map[string]string{
+		"one": `
+func goscript() (int, error) {
+	return 1, nil
+}
+`,
+		"two": `
+func goscript() (int, error) {
+	return 2, nil
+}
+`,
+	}
+	progs, err := PrecompileAll(scripts)
+	is.NoErr(err) // PrecompileAll
+	modTimes := make(map[string]time.Time)
+	for name, prog := range progs {
+		is.Equal(prog.binary, filepath.Join(dir, "goscript", CacheKey(scripts[name])+exeSuffix))
+		info, err := os.Stat(prog.binary)
+		is.NoErr(err) // cached binary
+		modTimes[name] = info.ModTime()
+		is.NoErr(prog.Close())
+	}
+
+	// precompiling again uses the cached binaries
+	progs, err = PrecompileAll(scripts)
+	is.NoErr(err) // PrecompileAll again
+	for name, prog := range progs {
+		info, err := os.Stat(prog.binary)
+		is.NoErr(err)
+		is.Equal(info.ModTime(), modTimes[name]) // not compiled again
+		script := prog.New()
+		out, err := script.Execute()
+		script.Close()
+		is.NoErr(err) // Execute
+		is.Equal(fmt.Sprint(out), map[string]string{"one": "1", "two": "2"}[name])
+	}
+
+	// GoBuild compiles to a temporary directory
+	progs, err = PrecompileAll(scripts, WithExecMode(GoBuild))
+	is.NoErr(err) // PrecompileAll with GoBuild
+	for _, prog := range progs {
+		is.True(!strings.HasPrefix(prog.binary, dir))
+		is.NoErr(prog.Close())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	is := is.New(t)
+	is.NoErr(Validate(`
+func goscript() (int, error) {
+	return 1, nil
+}
+`))
+	err := Validate(`
+func goscript() (int, error) {
+	return "one", nil
+}
+`)
+	is.True(err != nil)                                    // compile error
+	is.True(strings.HasPrefix(err.Error(), "goscript:3:")) // line of the script
+	err = Validate(`func notgoscript() {}`)
+	is.True(err != nil) // no func goscript
+}
+
+func TestRun(t *testing.T) {
+	is := is.New(t)
+	isolateScriptCache(t)
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	script := `
+import (
+	"fmt"
+	"syscall"
+)
+
+func goscript(name string) (string, error) {
+	return fmt.Sprintf("Hello %s from %d", name, syscall.Getpid()), nil
+}
+`
+	for i := 0; i < 2; i++ {
+		v, err := Run(script, "Mat")
+		is.NoErr(err) // Run
+		var name string
+		var pid int
+		_, err = fmt.Sscanf(v.(string), "Hello %s from %d", &name, &pid)
+		is.NoErr(err) // Sscanf
+		is.Equal(name, "Mat")
+		proc, err := os.FindProcess(pid)
+		if err == nil {
+			is.True(proc.Signal(syscall.Signal(0)) != nil) // process still running
+		}
+		entries, err := ioutil.ReadDir(tmp)
+		is.NoErr(err)             // ReadDir
+		is.Equal(len(entries), 0) // temporary files left behind
+	}
+	dir, err := cacheDir()
+	is.NoErr(err) // cacheDir
+	entries, err := ioutil.ReadDir(dir)
+	is.NoErr(err)             // ReadDir
+	is.Equal(len(entries), 1) // one cached binary
+}
+
+func TestCacheKey(t *testing.T) {
+	is := is.New(t)
+	isolateScriptCache(t)
+	script := `
+func goscript(name string) (string, error) {
+	return "Hello " + name, nil
+}
+`
+	key := CacheKey(script)
+	is.Equal(key, CacheKey(script))
+	tagged := CacheKey(script, WithBuildTags("goscripttest"))
+	is.True(tagged != key) // build tags change the key
+	is.True(CacheKey(script, WithLDFlags("-s -w")) != key)
+
+	prog, err := cachedProgram(script, options{})
+	is.NoErr(err) // cachedProgram
+	is.Equal(filepath.Base(prog.binary), key+exeSuffix)
+	var o options
+	WithBuildTags("goscripttest")(&o)
+	prog, err = cachedProgram(script, o)
+	is.NoErr(err) // cachedProgram with build tags
+	is.Equal(filepath.Base(prog.binary), tagged+exeSuffix)
+	dir, err := cacheDir()
+	is.NoErr(err) // cacheDir
+	entries, err := ioutil.ReadDir(dir)
+	is.NoErr(err)             // ReadDir
+	is.Equal(len(entries), 2) // recompiled for the build tag
+}
+
+func TestStartTimeout(t *testing.T) {
+	is := is.New(t)
+	// scripts that get stuck in an init function, or initializing a
+	// package-level variable
+	for _, stuck := range []string{`
+func init() {
+	time.Sleep(time.Minute)
+}
+`, `
+var stuck = func() bool {
+	time.Sleep(time.Minute)
+	return true
+}()
+`} {
+		prog, err := Compile(`
+import "time"
+`+stuck+`
+func goscript() (string, error) {
+	return "", nil
+}
+`, WithStartTimeout(500*time.Millisecond))
+		is.NoErr(err) // Compile
+		script := prog.New()
+		_, err = script.Execute()
+		script.Close()
+		prog.Close()
+		is.True(errors.Is(err, ErrStartTimeout))
+		is.True(strings.Contains(err.Error(), "the script started, but its initialization didn't finish"))
+	}
+
+	var o options
+	is.Equal(o.startLimit(), defaultStartTimeout)
+	WithStartTimeout(-1)(&o)
+	is.Equal(o.startLimit(), time.Duration(0)) // no limit
+}
+
+func TestBuildProgress(t *testing.T) {
+	is := is.New(t)
+	var lines []string
+	script := New(`
+import (
+	"encoding/json"
+	"strings"
+)
+
+func goscript(s string) (string, error) {
+	b, err := json.Marshal(strings.ToUpper(s))
+	return string(b), err
+}
+`, WithBuildProgress(func(line string) {
+		lines = append(lines, line)
+	}))
+	defer script.Close()
+	out, err := script.Execute("hi")
+	is.NoErr(err) // Execute
+	is.Equal(out, `"HI"`)
+	is.True(len(lines) > 0)
+	is.Equal(lines[len(lines)-1], "command-line-arguments")
+
+	lines = nil
+	script = New(`
+func goscript() (string, error) {
+	return undefinedName, nil
+}
+`, WithBuildProgress(func(line string) {
+		lines = append(lines, line)
+	}))
+	defer script.Close()
+	_, err = script.Execute()
+	is.True(err != nil)
+	is.Equal(err.Error(), "goscript:3:9: undefined: undefinedName")
+	is.True(len(lines) > 0)
+}
+
+func TestExecMode(t *testing.T) {
+	is := is.New(t)
+	cache := isolateScriptCache(t)
+	for _, tc := range []struct {
+		mode ExecMode
+		dir  string
+	}{
+		{GoRun, ""},
+		{GoBuild, os.TempDir()},
+		{Cached, cache},
+	} {
+		script := New(`
+func goscript(name string) (string, error) {
+	return "Hello " + name, nil
+}
+`, WithExecMode(tc.mode))
+		greeting, err := script.Execute("Mat")
+		is.NoErr(err) // Execute
+		is.Equal(greeting, "Hello Mat")
+		path := script.proc.cmd.Path
+		if tc.dir == "" {
+			is.Equal(filepath.Base(path), "go"+exeSuffix) // go run
+		} else {
+			is.True(strings.HasPrefix(path, tc.dir)) // binary run directly
+			is.True(script.proc.program != nil)
+		}
+		is.NoErr(script.Close())
+		if tc.mode == GoBuild {
+			_, err := os.Stat(path)
+			is.True(os.IsNotExist(err)) // binary removed by Close
+		}
+	}
+}
+
+func TestNewFromBinary(t *testing.T) {
+	is := is.New(t)
+	src := `
+func goscript(name string) (string, error) {
+	return "Hello " + name, nil
+}
+`
+	script := New(src, WithExecMode(GoBuild))
+	_, err := script.Execute("Mat")
+	is.NoErr(err) // Execute
+	built := script.BinaryPath()
+	is.True(built != "") // GoBuild binary
+	binary := filepath.Join(t.TempDir(), "greet"+exeSuffix)
+	b, err := ioutil.ReadFile(built)
+	is.NoErr(err) // read binary
+	is.NoErr(ioutil.WriteFile(binary, b, 0755))
+	is.NoErr(script.Close())
+
+	script = NewFromBinary(binary, src)
+	greeting, err := script.Execute("Mat")
+	is.NoErr(err) // Execute
+	is.Equal(greeting, "Hello Mat")
+	is.Equal(script.BinaryPath(), binary)
+	is.NoErr(script.Close())
+	_, err = os.Stat(binary)
+	is.NoErr(err) // binary left by Close
+
+	script = New(src)
+	defer script.Close()
+	is.Equal(script.BinaryPath(), "") // go run
+
+	script = NewFromBinary(filepath.Join(t.TempDir(), "missing"), src)
+	defer script.Close()
+	_, err = script.Execute("Mat")
+	is.True(err != nil) // missing binary
+}
+
+func TestNoCache(t *testing.T) {
+	is := is.New(t)
+	isolateScriptCache(t)
+	script := `
+func goscript(name string) (string, error) {
+	return "Hello " + name, nil
+}
+`
+	s := New(script, WithExecMode(Cached))
+	_, err := s.Execute("Mat")
+	is.NoErr(err) // Execute
+	is.NoErr(s.Close())
+	dir, err := cacheDir()
+	is.NoErr(err) // cacheDir
+	binary := filepath.Join(dir, CacheKey(script)+exeSuffix)
+	// spoil the cached binary
+	is.NoErr(ioutil.WriteFile(binary, []byte("stale"), 0700))
+
+	s = New(script, WithExecMode(Cached), WithNoCache())
+	defer s.Close()
+	greeting, err := s.Execute("Mat")
+	is.NoErr(err) // Execute after recompiling
+	is.Equal(greeting, "Hello Mat")
+	b, err := ioutil.ReadFile(binary)
+	is.NoErr(err)                 // ReadFile
+	is.True(string(b) != "stale") // cached binary replaced
+
+	is.NoErr(InvalidateCache(script))
+	_, err = os.Stat(binary)
+	is.True(os.IsNotExist(err)) // cached binary removed
+	is.NoErr(InvalidateCache(script))
+}
+
+func TestCorruptCache(t *testing.T) {
+	is := is.New(t)
+	isolateScriptCache(t)
+	script := `
+func goscript(name string) (string, error) {
+	return "Hello " + name, nil
+}
+`
+	s := New(script, WithExecMode(Cached))
+	_, err := s.Execute("Mat")
+	is.NoErr(err) // Execute
+	is.NoErr(s.Close())
+	dir, err := cacheDir()
+	is.NoErr(err) // cacheDir
+	binary := filepath.Join(dir, CacheKey(script)+exeSuffix)
+	is.NoErr(ioutil.WriteFile(binary, []byte("corrupt"), 0700))
+
+	s = New(script, WithExecMode(Cached))
+	defer s.Close()
+	greeting, err := s.Execute("Mat")
+	is.NoErr(err) // Execute after recompiling
+	is.Equal(greeting, "Hello Mat")
+
+	is.NoErr(ClearCache())
+	_, err = os.Stat(dir)
+	is.True(os.IsNotExist(err)) // cache removed
+}
+
+// isolateScriptCache points the cache of compiled scripts at a
+// temporary directory, which it returns, while keeping the go build
+// cache, so the standard library isn't compiled again.
+func isolateScriptCache(t *testing.T) string {
+	t.Helper()
+	gocache, err := exec.Command("go", "env", "GOCACHE").Output()
+	if err != nil {
+		t.Fatalf("go env: %v", err)
+	}
+	t.Setenv("GOCACHE", strings.TrimSpace(string(gocache)))
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", dir)
+	return dir
+}
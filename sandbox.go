@@ -0,0 +1,117 @@
+package goscript
+
+// SandboxProfile combines the options that restrict what a script
+// can do, for running semi-trusted scripts.
+type SandboxProfile struct {
+	// Seccomp holds the system calls the script is killed for
+	// making (see WithSeccomp). If it denies none, no filter is
+	// installed.
+	Seccomp SeccompProfile
+	// CleanEnv runs the script with an empty environment, so it
+	// can't read the caller's environment variables.
+	CleanEnv bool
+	// MemoryLimit is a soft limit on the memory used by the
+	// script, in bytes, or zero for no limit. It is set with
+	// GOMEMLIMIT, so the garbage collector works harder to stay
+	// under it, but allocations beyond it still succeed; Limits
+	// enforces a limit.
+	MemoryLimit int64
+	// Limits holds the memory and CPU time the script process is
+	// killed for using more of (see WithResourceLimits). If it is
+	// zero, there are no limits.
+	Limits ResourceLimits
+	// AllowedImports lists the only packages the script can
+	// import (see WithAllowedImports). If it is nil, any package
+	// can be imported.
+	AllowedImports []string
+}
+
+// computeImports lists the standard packages that only compute, for
+// SandboxStrict.
+var computeImports = []string{
+	"bufio", "bytes", "cmp", "container/heap", "container/list",
+	"container/ring", "context", "crypto/hmac", "crypto/md5",
+	"crypto/sha1", "crypto/sha256", "crypto/sha512", "encoding/base32",
+	"encoding/base64", "encoding/binary", "encoding/csv", "encoding/hex",
+	"encoding/json", "encoding/xml", "errors", "fmt", "hash",
+	"hash/crc32", "hash/fnv", "html", "io", "iter", "log", "maps",
+	"math", "math/big", "math/bits", "math/cmplx", "math/rand", "path",
+	"regexp", "slices", "sort", "strconv", "strings", "sync",
+	"sync/atomic", "text/tabwriter", "text/template", "time", "unicode",
+	"unicode/utf16", "unicode/utf8",
+}
+
+// generalImports lists the standard packages for SandboxModerate,
+// which adds files, the network and compression to computeImports,
+// but not running programs or getting around the type system.
+var generalImports = append(append([]string{}, computeImports...),
+	"archive/tar", "archive/zip", "compress/flate", "compress/gzip",
+	"compress/zlib", "crypto/rand", "crypto/tls", "encoding/gob",
+	"html/template", "io/fs", "io/ioutil", "log/slog", "mime",
+	"mime/multipart", "net", "net/http", "net/mail", "net/netip",
+	"net/url", "os", "os/signal", "path/filepath", "reflect", "runtime",
+)
+
+// SandboxModerate stops scripts running other programs or changing
+// the filesystem structure or permissions (DefaultSeccompProfile),
+// hides the caller's environment, and kills them for using more than
+// 1GiB of memory (Limits), with the garbage collector aiming to stay
+// under 768MiB (MemoryLimit). Scripts can only import standard
+// packages for computing, files, the network and compression, so not
+// os/exec, syscall, unsafe, plugin or any outside the standard
+// library. They can still read and write existing files, and use the
+// network.
+var SandboxModerate = SandboxProfile{
+	Seccomp:        DefaultSeccompProfile,
+	CleanEnv:       true,
+	MemoryLimit:    768 << 20,
+	Limits:         ResourceLimits{MaxMemoryBytes: 1 << 30},
+	AllowedImports: generalImports,
+}
+
+// SandboxStrict is SandboxModerate that also stops scripts opening
+// files or sockets, so they can only compute with their arguments,
+// and kills them for using more than 256MiB of memory, with the
+// garbage collector aiming to stay under 192MiB. Scripts can only
+// import standard packages that compute, such as strings, fmt and
+// encoding/json, and not os or net.
+// Files and connections passed in with WithExtraFiles can still be
+// used.
+var SandboxStrict = SandboxProfile{
+	Seccomp: SeccompProfile{
+		Deny: append(append([]string{}, DefaultSeccompProfile.Deny...),
+			// network
+			"socket", "socketpair", "connect", "bind", "listen", "accept", "accept4",
+			// opening files
+			"open", "openat", "openat2", "creat",
+		),
+	},
+	CleanEnv:       true,
+	MemoryLimit:    192 << 20,
+	Limits:         ResourceLimits{MaxMemoryBytes: 256 << 20},
+	AllowedImports: computeImports,
+}
+
+// WithSandbox applies the restrictions of a SandboxProfile to the
+// script, such as SandboxModerate or SandboxStrict.
+// The script is compiled before it is run (see WithExecMode), so the
+// restrictions apply to the script but not the go tool.
+// Profiles with a seccomp filter have the same platform requirements
+// as WithSeccomp: Linux on amd64 or arm64, with a kernel of 4.14 or
+// later, and those with Limits require Linux, as WithResourceLimits
+// does. Elsewhere New fails with an error.
+func WithSandbox(profile SandboxProfile) Option {
+	return func(o *options) {
+		if len(profile.Seccomp.Deny) > 0 {
+			WithSeccomp(profile.Seccomp)(o)
+		}
+		o.cleanEnv = profile.CleanEnv
+		o.memoryLimit = profile.MemoryLimit
+		if profile.Limits != (ResourceLimits{}) {
+			WithResourceLimits(profile.Limits)(o)
+		}
+		if profile.AllowedImports != nil {
+			WithAllowedImports(profile.AllowedImports...)(o)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+//go:build linux
+
+package goscript
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestSandboxStrict(t *testing.T) {
+	// the system calls are blocked even for scripts that can import
+	// syscall
+	profile := SandboxStrict
+	profile.AllowedImports = nil
+	for name, call := range map[string]string{
+		"network":    `syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)`,
+		"filesystem": `syscall.Open("/etc/hostname", syscall.O_RDONLY, 0)`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			is := is.New(t)
+			script := New(`
+import (
+	"strings"
+	"syscall"
+)
+
+func goscript(n int) ([]string, error) {
+	if n < 0 {
+		`+call+`
+		return nil, nil
+	}
+	return append([]string{strings.Repeat("x", n)}, syscall.Environ()...), nil
+}
+`, WithSandbox(profile))
+			defer script.Close()
+			v, err := script.Execute(3)
+			if err != nil && strings.Contains(err.Error(), "goscript: seccomp:") {
+				t.Skip("seccomp unavailable:", err)
+			}
+			is.NoErr(err) // Execute
+			is.Equal(v, []string{"xxx", "GOMEMLIMIT=201326592"})
+			_, err = script.Execute(-1)
+			var gerr Error
+			is.True(errors.As(err, &gerr))
+			is.Equal(gerr.Err, ErrBlockedSyscall)
+		})
+	}
+}
+
+func TestSandboxStrictLimits(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import "strings"
+
+var kept []string
+
+func goscript(mb int) (int, error) {
+	for i := 0; i < mb; i++ {
+		kept = append(kept, strings.Repeat("x", 1<<20))
+	}
+	return len(kept), nil
+}
+`, WithSandbox(SandboxStrict))
+	defer script.Close()
+	n, err := script.Execute(10)
+	if err != nil && strings.Contains(err.Error(), "goscript: seccomp:") {
+		t.Skip("seccomp unavailable:", err)
+	}
+	is.NoErr(err) // Execute
+	is.Equal(n, 10)
+	_, err = script.Execute(512)
+	is.True(errors.Is(err, ErrResourceLimit)) // memory is capped
+
+	// packages that reach outside the process can't be imported
+	for _, pkg := range []string{"os", "net", "syscall"} {
+		_, err := NewScript(`
+import _ "`+pkg+`"
+
+func goscript() (int, error) {
+	return 0, nil
+}
+`, WithSandbox(SandboxStrict))
+		is.True(err != nil)
+		is.Equal(err.Error(), `goscript:2: import "`+pkg+`" is not allowed`)
+	}
+}
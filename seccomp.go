@@ -0,0 +1,70 @@
+package goscript
+
+import "errors"
+
+// ErrBlockedSyscall is the Err of the Error returned when a script is
+// killed for making a system call denied by its SeccompProfile.
+var ErrBlockedSyscall = errors.New("goscript: script killed for making a blocked system call")
+
+// SeccompProfile describes the system calls a script is not allowed
+// to make.
+type SeccompProfile struct {
+	// Deny holds the names of the denied system calls, as they
+	// appear in the Linux syscall table (e.g. "execve").
+	// Calls that don't exist on the platform are ignored.
+	Deny []string
+}
+
+// DefaultSeccompProfile stops scripts running other programs, tracing
+// other processes, changing the filesystem structure or permissions,
+// and loading code into the kernel.
+// Reading and writing existing files is still allowed.
+var DefaultSeccompProfile = SeccompProfile{
+	Deny: []string{
+		// running programs and tracing
+		"execve", "execveat", "ptrace", "process_vm_readv", "process_vm_writev",
+		// filesystem
+		"unlink", "unlinkat", "rename", "renameat", "renameat2",
+		"mkdir", "mkdirat", "rmdir", "link", "linkat", "symlink", "symlinkat",
+		"chmod", "fchmod", "fchmodat", "chown", "fchown", "lchown", "fchownat",
+		"truncate", "mount", "umount2", "pivot_root", "chroot",
+		// system
+		"reboot", "kexec_load", "init_module", "finit_module", "delete_module",
+		"bpf", "seccomp",
+	},
+}
+
+// WithSeccomp applies a seccomp-bpf filter to the script process, so
+// that making any of the denied system calls kills it.
+// Executions that end this way return an Error whose Err is
+// ErrBlockedSyscall.
+//
+// The filter is installed when the script process initializes, after
+// it has been compiled, so the Go toolchain is unaffected. It is
+// applied before the script's package-level variables are initialized
+// and its init functions run, though after those of the packages it
+// imports.
+//
+// Seccomp is only supported on Linux (amd64 and arm64) with a kernel
+// of 4.14 or later; elsewhere New fails with an error. No special
+// privileges are required, since the filter is installed with
+// no_new_privs set.
+func WithSeccomp(profile SeccompProfile) Option {
+	return func(o *options) {
+		o.seccomp = &profile
+	}
+}
+
+// sockFilter is a classic BPF instruction.
+type sockFilter struct {
+	Code   uint16
+	Jt, Jf uint8
+	K      uint32
+}
+
+// seccompProgram is the filter installed by the script harness.
+type seccompProgram struct {
+	// Syscall is the number of the seccomp system call.
+	Syscall int
+	Filter  []sockFilter
+}
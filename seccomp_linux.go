@@ -0,0 +1,129 @@
+//go:build linux
+
+package goscript
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+const (
+	bpfLdAbsW = 0x20 // BPF_LD | BPF_W | BPF_ABS
+	bpfJeqK   = 0x15 // BPF_JMP | BPF_JEQ | BPF_K
+	bpfJgeK   = 0x35 // BPF_JMP | BPF_JGE | BPF_K
+	bpfRetK   = 0x06 // BPF_RET | BPF_K
+
+	seccompRetKillProcess = 0x80000000
+	seccompRetAllow       = 0x7fff0000
+
+	auditArchX8664   = 0xc000003e
+	auditArchAarch64 = 0xc00000b7
+
+	// x32ABIBit marks x32 system calls on amd64, which would
+	// otherwise bypass the filter.
+	x32ABIBit = 0x40000000
+)
+
+// seccompArch describes how to filter system calls on an architecture.
+type seccompArch struct {
+	audit    uint32
+	syscalls map[string]int
+}
+
+var seccompArches = map[string]seccompArch{
+	"amd64": {
+		audit: auditArchX8664,
+		syscalls: map[string]int{
+			"execve": 59, "execveat": 322, "ptrace": 101,
+			"process_vm_readv": 310, "process_vm_writev": 311,
+			"unlink": 87, "unlinkat": 263, "rename": 82, "renameat": 264, "renameat2": 316,
+			"mkdir": 83, "mkdirat": 258, "rmdir": 84, "link": 86, "linkat": 265,
+			"symlink": 88, "symlinkat": 266, "chmod": 90, "fchmod": 91, "fchmodat": 268,
+			"chown": 92, "fchown": 93, "lchown": 94, "fchownat": 260, "truncate": 76,
+			"mount": 165, "umount2": 166, "pivot_root": 155, "chroot": 161,
+			"reboot": 169, "kexec_load": 246, "init_module": 175, "finit_module": 313,
+			"delete_module": 176, "bpf": 321, "seccomp": 317,
+			"socket": 41, "socketpair": 53, "connect": 42, "bind": 49, "listen": 50,
+			"accept": 43, "accept4": 288,
+			"open": 2, "openat": 257, "openat2": 437, "creat": 85,
+		},
+	},
+	"arm64": {
+		audit: auditArchAarch64,
+		syscalls: map[string]int{
+			"execve": 221, "execveat": 281, "ptrace": 117,
+			"process_vm_readv": 270, "process_vm_writev": 271,
+			"unlink": -1, "unlinkat": 35, "rename": -1, "renameat": 38, "renameat2": 276,
+			"mkdir": -1, "mkdirat": 34, "rmdir": -1, "link": -1, "linkat": 37,
+			"symlink": -1, "symlinkat": 36, "chmod": -1, "fchmod": 52, "fchmodat": 53,
+			"chown": -1, "fchown": 55, "lchown": -1, "fchownat": 54, "truncate": 45,
+			"mount": 40, "umount2": 39, "pivot_root": 41, "chroot": 51,
+			"reboot": 142, "kexec_load": 104, "init_module": 105, "finit_module": 273,
+			"delete_module": 106, "bpf": 280, "seccomp": 277,
+			"socket": 198, "socketpair": 199, "connect": 203, "bind": 200, "listen": 201,
+			"accept": 202, "accept4": 242,
+			"open": -1, "openat": 56, "openat2": 437, "creat": -1,
+		},
+	},
+}
+
+// newSeccompProgram compiles the profile into a BPF program that kills
+// the process if it makes a denied system call.
+func newSeccompProgram(profile SeccompProfile) (*seccompProgram, error) {
+	arch, ok := seccompArches[runtime.GOARCH]
+	if !ok {
+		return nil, fmt.Errorf("goscript: seccomp is not supported on %s", runtime.GOARCH)
+	}
+	var jumps []sockFilter
+	if runtime.GOARCH == "amd64" {
+		jumps = append(jumps, sockFilter{Code: bpfJgeK, K: x32ABIBit})
+	}
+	for _, name := range profile.Deny {
+		nr, ok := arch.syscalls[name]
+		if !ok {
+			return nil, fmt.Errorf("goscript: seccomp: unknown system call %q", name)
+		}
+		if nr < 0 {
+			continue // not on this architecture
+		}
+		jumps = append(jumps, sockFilter{Code: bpfJeqK, K: uint32(nr)})
+	}
+	if len(jumps) > 255 {
+		return nil, fmt.Errorf("goscript: seccomp: too many system calls (%d)", len(jumps))
+	}
+	// each jump goes to the kill instruction at the end when it matches
+	for i := range jumps {
+		jumps[i].Jt = uint8(len(jumps) - i)
+	}
+	filter := []sockFilter{
+		{Code: bpfLdAbsW, K: 4}, // arch
+		{Code: bpfJeqK, Jt: 1, K: arch.audit},
+		{Code: bpfRetK, K: seccompRetKillProcess},
+		{Code: bpfLdAbsW, K: 0}, // syscall number
+	}
+	filter = append(filter, jumps...)
+	filter = append(filter,
+		sockFilter{Code: bpfRetK, K: seccompRetAllow},
+		sockFilter{Code: bpfRetK, K: seccompRetKillProcess},
+	)
+	return &seccompProgram{
+		Syscall: arch.syscalls["seccomp"],
+		Filter:  filter,
+	}, nil
+}
+
+// killedBySIGSYS gets whether the script was killed by a seccomp filter,
+// either directly or as reported by go run.
+func killedBySIGSYS(err error, stderr string) bool {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGSYS {
+			return true
+		}
+	}
+	return strings.Contains(stderr, "signal: bad system call")
+}
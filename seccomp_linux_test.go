@@ -0,0 +1,66 @@
+//go:build linux
+
+package goscript
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestSeccomp(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import "syscall"
+
+func goscript(n int) (int, error) {
+	if n < 0 {
+		err := syscall.Exec("/bin/true", []string{"true"}, nil)
+		return 0, err
+	}
+	return n * 2, nil
+}
+`, WithSeccomp(DefaultSeccompProfile))
+	defer script.Close()
+	v, err := script.Execute(21)
+	if err != nil && strings.Contains(err.Error(), "goscript: seccomp:") {
+		t.Skip("seccomp unavailable:", err)
+	}
+	is.NoErr(err) // Execute
+	is.Equal(v, 42)
+	_, err = script.Execute(-1)
+	var gerr Error
+	is.True(errors.As(err, &gerr))
+	is.Equal(gerr.Err, ErrBlockedSyscall)
+}
+
+func TestSeccompInitializers(t *testing.T) {
+	is := is.New(t)
+	// a.go is compiled after the script, unless the script is in a
+	// module, when it comes first
+	for _, opt := range []Option{WithBuildTags(), WithModule("example.com/script")} {
+		script := New(`
+import "os/exec"
+
+var escaped = exec.Command("/bin/true").Run() == nil
+
+func goscript() (bool, error) {
+	return escaped || helped, nil
+}
+`, WithSeccomp(DefaultSeccompProfile), opt, WithExtraFile("a.go", `package main
+
+import "os/exec"
+
+var helped = exec.Command("/bin/true").Run() == nil
+`))
+		escaped, err := script.Execute()
+		script.Close()
+		if err != nil && strings.Contains(err.Error(), "goscript: seccomp:") {
+			t.Skip("seccomp unavailable:", err)
+		}
+		is.NoErr(err)            // Execute
+		is.Equal(escaped, false) // package-level variables can't run programs
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package goscript
+
+import "errors"
+
+func newSeccompProgram(profile SeccompProfile) (*seccompProgram, error) {
+	return nil, errors.New("goscript: seccomp is only supported on linux")
+}
+
+func killedBySIGSYS(err error, stderr string) bool {
+	return false
+}
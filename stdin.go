@@ -0,0 +1,164 @@
+package goscript
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// WithStdin gives the script r as its stdin, so it can read input with
+// os.Stdin as programs usually do:
+//
+//	script := goscript.New(`
+//	import (
+//		"bufio"
+//		"os"
+//	)
+//
+//	func goscript() (int, error) {
+//		lines := 0
+//		s := bufio.NewScanner(os.Stdin)
+//		for s.Scan() {
+//			lines++
+//		}
+//		return lines, s.Err()
+//	}
+//	`, goscript.WithStdin(os.Stdin))
+//
+// The script reads r as a stream across its calls, and reaches the
+// end of it once r is used up; if the process is restarted, the new
+// one reads on from where copying to the old one stopped, though what
+// the old one was given but didn't read is lost. Requests are sent to
+// the script on a pipe of its own instead of stdin, after any extra
+// files (see WithExtraFiles).
+// Not supported on Windows.
+func WithStdin(r io.Reader) Option {
+	return func(o *options) {
+		o.stdin = newStdinFeeder(r)
+	}
+}
+
+// stdinFeeder copies a reader to the stdin of the newest script
+// process, so that one goroutine reads it, however many processes
+// are started in turn.
+type stdinFeeder struct {
+	r    io.Reader
+	mu   sync.Mutex
+	cond *sync.Cond
+	// w is the stdin of the newest process, or nil once that has
+	// exited or been closed.
+	w *os.File
+	// procs counts the processes fed that haven't been closed, and
+	// copying is set while the goroutine copying r runs.
+	procs   int
+	copying bool
+	// pending holds what has been read from r but not written, and
+	// eof is set once r is used up.
+	pending []byte
+	eof     bool
+}
+
+func newStdinFeeder(r io.Reader) *stdinFeeder {
+	f := &stdinFeeder{r: r}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// pipe gives cmd a pipe that r is copied to as its stdin, and a pipe
+// after its other extra files to take requests on, whose write end is
+// returned, along with the files given to cmd, which are closed once
+// it has started, and a func to call once the process is closed.
+func (f *stdinFeeder) pipe(cmd *exec.Cmd) (io.WriteCloser, []*os.File, func(), error) {
+	in, inw, err := os.Pipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	requests, requestsw, err := os.Pipe()
+	if err != nil {
+		in.Close()
+		inw.Close()
+		return nil, nil, nil, err
+	}
+	cmd.Stdin = in
+	n := len(cmd.ExtraFiles)
+	cmd.ExtraFiles = append(cmd.ExtraFiles[:n:n], requests)
+	return requestsw, []*os.File{in, requests}, f.feed(inw), nil
+}
+
+// feed makes w the stdin to copy r to, in place of the previous
+// process's, and returns the func that stops feeding it.
+func (f *stdinFeeder) feed(w *os.File) func() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.eof && len(f.pending) == 0 {
+		w.Close()
+		return func() {}
+	}
+	if f.w != nil {
+		// a write in progress fails, and is finished on w
+		f.w.Close()
+	}
+	f.w = w
+	f.procs++
+	if !f.copying {
+		f.copying = true
+		go f.copy()
+	}
+	f.cond.Broadcast()
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.procs--
+		if f.w == w {
+			f.w.Close()
+			f.w = nil
+		}
+		f.cond.Broadcast()
+	}
+}
+
+// copy copies r to the newest process's stdin until r is used up, or
+// there are no processes left to feed, keeping anything read but not
+// yet written for the next process.
+func (f *stdinFeeder) copy() {
+	buf := make([]byte, 32<<10)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for {
+		if len(f.pending) == 0 && !f.eof {
+			f.mu.Unlock()
+			n, err := f.r.Read(buf)
+			f.mu.Lock()
+			f.pending = buf[:n]
+			f.eof = err != nil
+		}
+		for f.w == nil && f.procs > 0 {
+			f.cond.Wait()
+		}
+		if f.w == nil {
+			f.copying = false
+			return
+		}
+		w := f.w
+		if len(f.pending) == 0 {
+			if !f.eof {
+				continue
+			}
+			// r is used up
+			w.Close()
+			f.w = nil
+			f.copying = false
+			return
+		}
+		f.mu.Unlock()
+		n, err := w.Write(f.pending)
+		f.mu.Lock()
+		f.pending = f.pending[n:]
+		if err != nil && f.w == w {
+			// the process has exited, so wait for the next one
+			w.Close()
+			f.w = nil
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package goscript
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestStdin(t *testing.T) {
+	for _, mode := range []ExecMode{GoRun, GoBuild} {
+		is := is.New(t)
+		script := New(`
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+var in = bufio.NewReader(os.Stdin)
+
+func goscript() (string, error) {
+	line, err := in.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(strings.TrimSpace(line)), nil
+}
+`, WithStdin(strings.NewReader("first\nsecond\n")), WithExecMode(mode))
+		defer script.Close()
+		line, err := script.Execute()
+		is.NoErr(err) // first line
+		is.Equal(line, "FIRST")
+		line, err = script.Execute()
+		is.NoErr(err) // second line
+		is.Equal(line, "SECOND")
+		_, err = script.Execute()
+		is.Equal(err.Error(), "EOF") // end of stdin
+		is.NoErr(script.Ping())      // protocol unaffected
+	}
+}
+
+func TestStdinRestart(t *testing.T) {
+	is := is.New(t)
+	r, w := io.Pipe()
+	defer w.Close()
+	script := New(`
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+var in = bufio.NewReader(os.Stdin)
+
+func goscript() (string, error) {
+	line, err := in.ReadString('\n')
+	return strings.TrimSpace(line), err
+}
+`, WithStdin(r), WithExecMode(GoBuild))
+	defer script.Close()
+	for _, line := range []string{"first", "second", "third"} {
+		go io.WriteString(w, line+"\n")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		v, err := script.ExecuteContext(ctx)
+		cancel()
+		is.NoErr(err) // each line reaches the current process
+		is.Equal(v, line)
+		// the new process reads on from the same input
+		is.NoErr(script.Reset())
+	}
+}
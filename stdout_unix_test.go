@@ -0,0 +1,56 @@
+//go:build unix
+
+package goscript
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestStdoutResultDescriptor(t *testing.T) {
+	is := is.New(t)
+	src := `
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// stdout is saved before the harness could swap os.Stdout
+var stdout = os.Stdout
+
+func init() {
+	fmt.Print("starting ")
+}
+
+func goscript(s string) error {
+	fmt.Fprint(stdout, s+"1 ")
+	if _, err := syscall.Write(1, []byte(s+"2 ")); err != nil {
+		return err
+	}
+	cmd := exec.Command("printf", "%s", s+"3")
+	cmd.Stdout = os.Stdout
+	return cmd.Run()
+}
+`
+	for _, opts := range [][]Option{
+		{WithStdoutResult()},
+		{WithStdoutResult(), WithExecMode(GoBuild), WithStdin(strings.NewReader(""))},
+	} {
+		script := New(src, opts...)
+		var output bytes.Buffer
+		script.SetOutput(&output)
+		out, err := script.Execute("a")
+		is.NoErr(err) // Execute
+		is.Equal(out, "a1 a2 a3")
+		out, err = script.Execute("b")
+		is.NoErr(err) // Execute
+		is.Equal(out, "b1 b2 b3")
+		is.NoErr(script.Close())
+		is.Equal(output.String(), "starting ") // written outside calls
+	}
+}
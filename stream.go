@@ -0,0 +1,252 @@
+package goscript
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ExecuteReader executes the script with the specified arguments,
+// where the script returns an io.Reader, and returns a reader for
+// its contents. The contents are streamed from the script as the
+// caller reads them, rather than being buffered up front:
+//
+//	func goscript(path string) (io.Reader, error) {
+//		return os.Open(path)
+//	}
+//
+// If the script returns an io.Closer too, it is closed once it has
+// been read.
+// The script can read ahead of the caller by a limited number of
+// chunks, after which it waits for the caller to catch up.
+// Errors reading from the script's reader are returned by Read.
+// Caller must close the returned reader, and other calls to the
+// script process wait until it is closed, though the Script can be
+// closed or reloaded meanwhile, which ends the stream with an error.
+func (s *Script) ExecuteReader(args ...interface{}) (io.ReadCloser, error) {
+	p, err := s.streamProcess()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	res, err := p.begin(context.Background(), request{Args: args, Stream: true}, s.types)
+	if err != nil {
+		s.endStream(p, start, err)
+		return nil, err
+	}
+	r := &resultReader{p: p}
+	r.release = func() {
+		p.executeLock.Unlock()
+		err := r.err
+		if err == io.EOF {
+			err = nil
+		}
+		s.endStream(p, start, err)
+	}
+	if err := r.frame(res); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ExecuteStream executes the script with the specified arguments,
+// and sends the values it yields on the returned channel as they are
+// yielded, rather than collecting them all first. The script yields
+// values by taking a parameter of type func(interface{}), which is
+// provided by goscript rather than passed to ExecuteStream:
+//
+//	func goscript(path string, yield func(interface{})) (interface{}, error) {
+//		f, err := os.Open(path)
+//		if err != nil {
+//			return nil, err
+//		}
+//		defer f.Close()
+//		s := bufio.NewScanner(f)
+//		for s.Scan() {
+//			yield(strings.ToUpper(s.Text()))
+//		}
+//		return nil, s.Err()
+//	}
+//
+// The value the script returns is sent last, unless it is nil.
+// The values channel is closed once the script has returned, after
+// which the error channel receives the error the call ended with,
+// if any, and is closed too.
+// The script waits for the caller once it has yielded a limited
+// number of values ahead of it. Caller must receive every value, and
+// other calls to the script process wait until the values channel is
+// closed, though the Script can be closed or reloaded meanwhile, which
+// ends the stream with an error.
+// Values yielded during calls made with Execute are dropped.
+func (s *Script) ExecuteStream(args ...interface{}) (<-chan interface{}, <-chan error) {
+	values := make(chan interface{})
+	errs := make(chan error, 1)
+	p, err := s.streamProcess()
+	if err != nil {
+		close(values)
+		errs <- err
+		close(errs)
+		return values, errs
+	}
+	start := time.Now()
+	res, err := p.begin(context.Background(), request{Args: args, Yield: true}, s.types)
+	if err != nil {
+		s.endStream(p, start, err)
+		close(values)
+		errs <- err
+		close(errs)
+		return values, errs
+	}
+	go func() {
+		defer close(errs)
+		err := streamValues(p, res, values)
+		close(values)
+		p.executeLock.Unlock()
+		s.endStream(p, start, err)
+		if err != nil {
+			errs <- err
+		}
+	}()
+	return values, errs
+}
+
+// streamProcess gets the process to stream a call from, restarting
+// it first if it was killed by a cancelled call, as executeOnce does.
+// The Script isn't kept locked while the call is streamed, so the
+// caller can use it in the meantime, such as to Close it.
+func (s *Script) streamProcess() (*process, error) {
+	s.ensureStarted()
+	s.mu.RLock()
+	p := s.proc
+	s.mu.RUnlock()
+	if p != nil && p.cancelled() {
+		s.restart(p)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.proc, nil
+}
+
+// endStream records a streamed call to p, which started at start and
+// ended with err, once p is free for other calls, as executeOnce and
+// execute do for other calls.
+func (s *Script) endStream(p *process, start time.Time, err error) {
+	elapsed := time.Since(start)
+	s.latency.observe(elapsed)
+	if s.opts.observer != nil {
+		s.opts.observer.OnExecute(elapsed, err)
+	}
+	if p.poisoned() {
+		// the call can't be made again once some of it has been
+		// streamed, but the process is replaced for the next one
+		if s.opts.autoRestart {
+			go s.autoRestart(p)
+		}
+		return
+	}
+	if s.opts.autoRestart {
+		atomic.StoreInt32(&s.restarts, 0)
+	}
+	if s.opts.maxExecutions > 0 && atomic.AddInt64(&p.executions, 1) >= int64(s.opts.maxExecutions) {
+		s.restart(p)
+	}
+}
+
+// streamValues sends the values yielded by the script, starting with
+// res, followed by the value it returns, and returns the error the
+// call ended with.
+func streamValues(p *process, res response, values chan<- interface{}) error {
+	for res.IsYield {
+		values <- res.Value
+		var err error
+		if res, err = p.receive(context.Background()); err != nil {
+			return err
+		}
+	}
+	if res.Panicked {
+		return PanicError{Value: res.Panic, Stack: res.Stack}
+	}
+	if res.Value != nil {
+		values <- res.Value
+	}
+	return res.Error
+}
+
+// resultReader reads a result streamed by the script as chunks,
+// up to the final response.
+type resultReader struct {
+	p       *process
+	release func()
+	buf     []byte
+	// done is set once the final response has been read, after
+	// which err is the error to return once buf is drained.
+	done bool
+	err  error
+}
+
+func (r *resultReader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, r.err
+		}
+		res, err := r.p.receive(context.Background())
+		if err != nil {
+			r.finish(err)
+			continue
+		}
+		r.frame(res)
+	}
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// frame handles a response read from the stream, and returns the
+// error the stream ended with, if it has ended.
+func (r *resultReader) frame(res response) error {
+	switch {
+	case res.IsChunk:
+		r.buf = res.Chunk
+		return nil
+	case res.Panicked:
+		r.finish(PanicError{Value: res.Panic, Stack: res.Stack})
+	case res.Error != nil:
+		r.finish(res.Error)
+	default:
+		r.finish(io.EOF)
+	}
+	return r.err
+}
+
+// finish ends the stream with err, and releases the script for
+// other calls.
+func (r *resultReader) finish(err error) {
+	r.done = true
+	r.err = err
+	r.release()
+}
+
+// Close reads and discards the rest of the stream, so the script can
+// take other calls.
+func (r *resultReader) Close() error {
+	r.buf = nil
+	for !r.done {
+		res, err := r.p.receive(context.Background())
+		if err != nil {
+			r.finish(err)
+			break
+		}
+		r.frame(res)
+		r.buf = nil
+	}
+	r.err = errClosedReader
+	return nil
+}
+
+// errClosedReader is returned by reads after Close.
+var errClosedReader = errors.New("goscript: read from closed result reader")
@@ -0,0 +1,353 @@
+package goscript
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestExecuteReader(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import (
+	"errors"
+	"io"
+)
+
+type xs struct {
+	n    int
+	fail bool
+}
+
+func (r *xs) Read(b []byte) (int, error) {
+	if r.n == 0 {
+		if r.fail {
+			return 0, errors.New("broken")
+		}
+		return 0, io.EOF
+	}
+	if len(b) > r.n {
+		b = b[:r.n]
+	}
+	for i := range b {
+		b[i] = 'x'
+	}
+	r.n -= len(b)
+	return len(b), nil
+}
+
+func goscript(n int, fail bool) (io.Reader, error) {
+	return &xs{n: n, fail: fail}, nil
+}
+`)
+	defer script.Close()
+
+	r, err := script.ExecuteReader(1<<20, false)
+	is.NoErr(err) // ExecuteReader
+	buf := make([]byte, 4096)
+	var total, reads int
+	for {
+		n, err := r.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		is.NoErr(err) // Read
+		is.Equal(strings.Count(string(buf[:n]), "x"), n)
+		total += n
+		reads++
+	}
+	is.NoErr(r.Close())
+	is.Equal(total, 1<<20)
+	is.True(reads >= (1<<20)/len(buf)) // read incrementally
+
+	r, err = script.ExecuteReader(100, true)
+	is.NoErr(err) // ExecuteReader
+	b, err := ioutil.ReadAll(r)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "broken"))
+	is.Equal(len(b), 100)
+	is.NoErr(r.Close())
+
+	// closing early discards the rest
+	r, err = script.ExecuteReader(1<<20, false)
+	is.NoErr(err) // ExecuteReader
+	_, err = r.Read(buf)
+	is.NoErr(err) // Read
+	is.NoErr(r.Close())
+	r, err = script.ExecuteReader(10, false)
+	is.NoErr(err) // ExecuteReader after Close
+	b, err = ioutil.ReadAll(r)
+	is.NoErr(err) // ReadAll
+	is.Equal(string(b), "xxxxxxxxxx")
+	is.NoErr(r.Close())
+}
+
+func TestExecuteReaderAccounting(t *testing.T) {
+	is := is.New(t)
+	obs := &countingObserver{}
+	script := New(`
+import (
+	"io"
+	"strconv"
+	"strings"
+)
+
+type xs struct{}
+
+func (xs) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 'x'
+	}
+	return len(b), nil
+}
+
+var calls int
+
+func goscript(n int64) (io.Reader, error) {
+	calls++
+	return io.MultiReader(strings.NewReader(strconv.Itoa(calls)), io.LimitReader(xs{}, n)), nil
+}
+`, WithObserver(obs), WithMaxExecutions(1))
+	defer script.Close()
+	for i := 0; i < 2; i++ {
+		r, err := script.ExecuteReader(int64(2))
+		is.NoErr(err) // ExecuteReader
+		b, err := ioutil.ReadAll(r)
+		is.NoErr(err)              // ReadAll
+		is.NoErr(r.Close())        // Close
+		is.Equal(string(b), "1xx") // restarted after each call
+	}
+	is.Equal(obs.counts()[1], 2) // observed executions
+	var observed int64
+	for _, bucket := range script.LatencyHistogram() {
+		observed += bucket.Count
+	}
+	is.Equal(observed, int64(2)) // latencies
+
+	// the Script can be used before the reader is closed
+	r, err := script.ExecuteReader(int64(1 << 40))
+	is.NoErr(err) // ExecuteReader
+	_, err = r.Read(make([]byte, 10))
+	is.NoErr(err) // Read
+	reset := make(chan error, 1)
+	go func() {
+		reset <- script.Reset()
+	}()
+	select {
+	case err := <-reset:
+		is.NoErr(err) // Reset
+	case <-time.After(time.Minute):
+		t.Fatal("Reset waited for the reader to be closed")
+	}
+	_, err = ioutil.ReadAll(r)
+	is.True(err != nil) // the stream ends with the process
+	r.Close()
+}
+
+func TestExecuteReaderSlowCaller(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+import (
+	"io"
+	"strings"
+)
+
+func goscript(setProgress func(int), steps, size int) (io.Reader, error) {
+	for i := 1; i <= steps; i++ {
+		setProgress(i)
+	}
+	return strings.NewReader(strings.Repeat("x", size)), nil
+}
+`)
+	defer script.Close()
+	const steps, size = 100000, 4 << 20
+	done := make(chan error)
+	go func() {
+		r, err := script.ExecuteReader(steps, size)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer r.Close()
+		if script.Progress() != steps {
+			done <- fmt.Errorf("progress %d, want %d", script.Progress(), steps)
+			return
+		}
+		buf := make([]byte, 32<<10)
+		total := 0
+		for {
+			// read more slowly than the script writes
+			time.Sleep(time.Millisecond)
+			m, err := r.Read(buf)
+			total += m
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		if total != size {
+			done <- fmt.Errorf("read %d bytes, want %d", total, size)
+			return
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		is.NoErr(err)
+	case <-time.After(time.Minute):
+		t.Fatal("deadlocked")
+	}
+}
+
+func TestExecuteStreamSlowCaller(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript(n int, yield func(interface{})) (interface{}, error) {
+	for i := 0; i < n; i++ {
+		yield(i)
+	}
+	return nil, nil
+}
+`)
+	defer script.Close()
+	const n = 5 * responseBuffer
+	values, errs := script.ExecuteStream(n)
+	got := 0
+	for v := range values {
+		// receive more slowly than the script yields
+		time.Sleep(time.Millisecond)
+		is.Equal(v, got)
+		got++
+	}
+	is.NoErr(<-errs) // ExecuteStream
+	is.Equal(got, n)
+}
+
+func TestResponseOverflow(t *testing.T) {
+	is := is.New(t)
+	var out bytes.Buffer
+	enc := newStreamEncoder(GobCodec, NoCompression, &out)
+	for i := 0; i < responseBuffer+1; i++ {
+		is.NoErr(enc.Encode(response{Value: i, IsYield: true}))
+	}
+	p := newProcess("", options{})
+	p.stdoutbuf = bufio.NewReader(&out)
+	p.responses = make(chan response, responseBuffer)
+	p.readResponses()
+	for i := 0; i < responseBuffer; i++ {
+		res := <-p.responses
+		is.Equal(res.Value, i)
+	}
+	_, ok := <-p.responses
+	is.True(!ok) // closed once full
+	is.Equal(p.readErr, ErrResponseOverflow)
+	is.True(p.poisoned())
+}
+
+func TestExecuteStream(t *testing.T) {
+	is := is.New(t)
+	script := New(`
+func goscript(n int, yield func(interface{})) (interface{}, error) {
+	for i := 0; i < n; i++ {
+		yield(i)
+	}
+	if n < 0 {
+		panic("negative")
+	}
+	return "done", nil
+}
+`)
+	defer script.Close()
+
+	const n = 1000
+	values, errs := script.ExecuteStream(n)
+	var got []interface{}
+	for v := range values {
+		got = append(got, v)
+	}
+	is.NoErr(<-errs) // ExecuteStream
+	is.Equal(len(got), n+1)
+	for i := 0; i < n; i++ {
+		is.Equal(got[i], i)
+	}
+	is.Equal(got[n], "done")
+
+	values, errs = script.ExecuteStream(-1)
+	for range values {
+		t.Fatal("unexpected value")
+	}
+	err := <-errs
+	var panicErr PanicError
+	is.True(errors.As(err, &panicErr))
+	is.Equal(panicErr.Value, "negative")
+
+	// values yielded by Execute are dropped
+	v, err := script.Execute(3)
+	is.NoErr(err) // Execute
+	is.Equal(v, "done")
+
+	values, errs = script.ExecuteStream()
+	for range values {
+	}
+	is.True(<-errs != nil) // missing argument
+}
+
+func TestExecuteStreamAccounting(t *testing.T) {
+	is := is.New(t)
+	obs := &countingObserver{}
+	script := New(`
+var calls int
+
+func goscript(n int, yield func(interface{})) (interface{}, error) {
+	calls++
+	for i := 0; i < n; i++ {
+		yield(i)
+	}
+	return calls, nil
+}
+`, WithObserver(obs), WithMaxExecutions(1))
+	defer script.Close()
+	for i := 0; i < 2; i++ {
+		values, errs := script.ExecuteStream(2)
+		var got []interface{}
+		for v := range values {
+			got = append(got, v)
+		}
+		is.NoErr(<-errs)                      // ExecuteStream
+		is.Equal(got, []interface{}{0, 1, 1}) // restarted after each call
+	}
+	is.Equal(obs.counts()[1], 2) // observed executions
+	var observed int64
+	for _, bucket := range script.LatencyHistogram() {
+		observed += bucket.Count
+	}
+	is.Equal(observed, int64(2)) // latencies
+
+	// the Script can be used before the stream is drained
+	values, errs := script.ExecuteStream(1000)
+	<-values
+	reset := make(chan error, 1)
+	go func() {
+		reset <- script.Reset()
+	}()
+	select {
+	case err := <-reset:
+		is.NoErr(err) // Reset
+	case <-time.After(time.Minute):
+		t.Fatal("Reset waited for the stream to be drained")
+	}
+	for range values {
+	}
+	is.True(<-errs != nil) // the stream ends with the process
+}
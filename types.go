@@ -0,0 +1,103 @@
+package goscript
+
+import (
+	"encoding/gob"
+	"fmt"
+	"reflect"
+)
+
+// commonTypes are registered with gob in both the caller and the
+// script process, so that values of these types can be passed to and
+// returned from scripts as interface{} values, including inside one
+// another. Basic types, and slices of them such as []byte, are
+// registered by gob itself.
+var commonTypes = []interface{}{
+	[]interface{}(nil),
+	[][]byte(nil),
+	[][]string(nil),
+	[][]int(nil),
+	[][]float64(nil),
+	[][]interface{}(nil),
+	map[string]interface{}(nil),
+	map[string]string(nil),
+	map[string]int(nil),
+	map[string]int64(nil),
+	map[string]float64(nil),
+	map[string]bool(nil),
+	map[string][]byte(nil),
+	map[string][]string(nil),
+	map[string][]interface{}(nil),
+	map[int]string(nil),
+	map[int]interface{}(nil),
+	[]map[string]interface{}(nil),
+	[]map[string]string(nil),
+}
+
+func init() {
+	for _, v := range commonTypes {
+		gob.Register(v)
+	}
+}
+
+// commonTypeNames gets the Go syntax for the types in commonTypes, for
+// the harness to register them too.
+func commonTypeNames() []string {
+	names := make([]string, len(commonTypes))
+	for i, v := range commonTypes {
+		names[i] = reflect.TypeOf(v).String()
+	}
+	return names
+}
+
+// typeRegistration describes a type to register with gob in the
+// script process.
+type typeRegistration struct {
+	// Name is the gob name.
+	Name string
+	// Type is the name of the struct type in the script.
+	Type string
+	// Ptr is whether a pointer to the type is being registered.
+	Ptr bool
+}
+
+// registerType registers v with gob if it is a named struct, or
+// a pointer to one, using the default gob name.
+// Other values are ignored, and ok is false.
+func registerType(v interface{}) (reg typeRegistration, ok bool, err error) {
+	rt := reflect.TypeOf(v)
+	if rt == nil {
+		return reg, false, nil
+	}
+	star := ""
+	if rt.Kind() == reflect.Ptr {
+		star = "*"
+		rt = rt.Elem()
+	}
+	if rt.Kind() != reflect.Struct || rt.Name() == "" {
+		return reg, false, nil
+	}
+	reg, err = registerTypeAs(star+rt.PkgPath()+"."+rt.Name(), v)
+	return reg, err == nil, err
+}
+
+// registerTypeAs registers v with gob under name.
+// v must be a named struct, or a pointer to one.
+func registerTypeAs(name string, v interface{}) (reg typeRegistration, err error) {
+	rt := reflect.TypeOf(v)
+	if rt != nil && rt.Kind() == reflect.Ptr {
+		reg.Ptr = true
+		rt = rt.Elem()
+	}
+	if rt == nil || rt.Kind() != reflect.Struct || rt.Name() == "" {
+		return reg, fmt.Errorf("goscript: register %s: %T is not a named struct type", name, v)
+	}
+	reg.Name = name
+	reg.Type = rt.Name()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("goscript: register %s: %v", name, r)
+		}
+	}()
+	gob.RegisterName(name, v)
+	return reg, nil
+}
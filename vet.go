@@ -0,0 +1,75 @@
+package goscript
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// WithVet sets whether the script is checked with go vet when it is
+// started, which catches mistakes that compile, such as Printf calls
+// whose arguments don't match the format, or code that can't be
+// reached. The problems go vet finds don't stop the script starting,
+// and are got with VetWarnings, at their lines in the script:
+//
+//	script := goscript.New(src, goscript.WithVet(true))
+//	for _, warning := range script.VetWarnings() {
+//		log.Println(warning)
+//	}
+//
+// Vetting the script takes a little longer than compiling it.
+func WithVet(vet bool) Option {
+	return func(o *options) {
+		o.vet = vet
+	}
+}
+
+// VetWarnings gets the problems go vet found in the script when it was
+// started, such as "goscript:4:2: fmt.Sprintf format %d has arg name
+// of wrong type string", if it was started WithVet.
+func (s *Script) VetWarnings() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.proc == nil {
+		return nil
+	}
+	return s.proc.vetWarnings
+}
+
+// vetScript runs go vet on the generated source, and gets the
+// problems it reports.
+func vetScript(src []byte, opts options) ([]string, error) {
+	dir, files, err := writeScriptFile(src, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	// go vet only takes the build flags that choose the files
+	args := []string{"vet"}
+	if len(opts.buildTags) > 0 {
+		args = append(args, "-tags", strings.Join(opts.buildTags, ","))
+	}
+	var cmd *exec.Cmd
+	if opts.module != nil {
+		cmd = exec.Command(opts.goCommand(), append(args, "-mod=mod", ".")...)
+		cmd.Dir = dir
+	} else {
+		cmd = exec.Command(opts.goCommand(), append(args, files...)...)
+	}
+	// go vet exits with an error when it finds problems
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+	var warnings []string
+	for _, line := range strings.Split(opts.redactor()(processOutput(out)), "\n") {
+		// problems are headed by their package
+		if line == "" || strings.HasPrefix(line, "# ") {
+			continue
+		}
+		warnings = append(warnings, line)
+	}
+	return warnings, nil
+}
@@ -0,0 +1,42 @@
+package goscript
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestVet(t *testing.T) {
+	is := is.New(t)
+	src := `
+import "fmt"
+
+func goscript(name string) (string, error) {
+	return fmt.Sprintf("Hello %d", name), nil
+}
+`
+	for _, mode := range []ExecMode{GoRun, GoBuild} {
+		script := New(src, WithVet(true), WithExecMode(mode))
+		defer script.Close()
+		greeting, err := script.Execute("Mat")
+		is.NoErr(err) // warnings don't stop the script
+		is.Equal(greeting, "Hello %!d(string=Mat)")
+		warnings := script.VetWarnings()
+		is.Equal(len(warnings), 1)
+		is.True(strings.HasPrefix(warnings[0], "goscript:5:")) // line of the script
+		is.True(strings.Contains(warnings[0], "Sprintf"))
+	}
+
+	script := New(src)
+	defer script.Close()
+	is.Equal(len(script.VetWarnings()), 0) // not vetted
+
+	script = New(`
+func goscript() (int, error) {
+	return 1, nil
+}
+`, WithVet(true))
+	defer script.Close()
+	is.Equal(len(script.VetWarnings()), 0) // nothing to report
+}